@@ -2,20 +2,49 @@ package gfadapter
 
 import (
 	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
 
 	"github.com/yclw/gf-casbin-adapter/dao"
-	"github.com/yclw/gf-casbin-adapter/model/entity"
 
 	"github.com/casbin/casbin/v2/model"
 	"github.com/casbin/casbin/v2/persist"
 	"github.com/gogf/gf/v2/database/gdb"
 	"github.com/gogf/gf/v2/frame/g"
 	"github.com/gogf/gf/v2/util/gconv"
+	"github.com/gogf/gf/v2/util/guid"
 )
 
+// ErrRuleExists is matched by the errors returned when added rules already exist.
+var ErrRuleExists = errors.New("rule already exists")
+
+// errConcurrentInsert reports rules added by another transaction while inserting.
+var errConcurrentInsert = errors.New("rules were added concurrently")
+
+// RuleExistsError reports the rules that already exist in the storage.
+type RuleExistsError struct {
+	Ptype string
+	Rules [][]string
+}
+
+// Error implements the error interface.
+func (e *RuleExistsError) Error() string {
+	return fmt.Sprintf("%s: %s %v", ErrRuleExists, e.Ptype, e.Rules)
+}
+
+// Is reports whether target is ErrRuleExists.
+func (e *RuleExistsError) Is(target error) bool {
+	return target == ErrRuleExists
+}
+
 // Filter filter conditions
 type Filter struct {
 	Ptype []string
@@ -25,6 +54,12 @@ type Filter struct {
 	V3    []string
 	V4    []string
 	V5    []string
+
+	// Cond further restricts the rules, e.g. And(HasPrefix("v1", "/api/orders/"), NotIn("v0", "guest")).
+	Cond Cond
+	// Sections restricts the rules of a section by its own condition.
+	// Keys are sections like "p" and "g", or ptypes like "p2" whose condition replaces the one of their section.
+	Sections map[string]Cond
 }
 
 var (
@@ -53,6 +88,9 @@ var (
 
 	// UpdatableAdapter updatable adapter
 	_ persist.UpdatableAdapter = new(Adapter)
+
+	// TransactionalAdapter transactional adapter
+	_ persist.TransactionalAdapter = new(Adapter)
 )
 
 type UserFiltered bool
@@ -62,73 +100,217 @@ const (
 	DisabledFiltered UserFiltered = false
 )
 
+// EnabledCreate is the table creation setting used by NewAdapterWithFiltered and NewAdapterWithName.
+//
+// Deprecated: use NewAdapterWithOptions with WithAutoCreateTable, which is scoped to one adapter.
 var EnabledCreate bool = true
 
 // Adapter represents the GoFrame adapter for policy storage.
 type Adapter struct {
-	dao        *dao.CasbinRuleDao
-	isFiltered UserFiltered
+	dao            *dao.CasbinRuleDao
+	isFiltered     UserFiltered // isFiltered is reported until rules have been loaded.
+	migrationTable string
+	changeLogTable string   // changeLogTable is empty if the change log is disabled.
+	auditTable     string   // auditTable is empty if the audit log is disabled.
+	snapshotTable  string   // snapshotTable is empty if snapshots are disabled.
+	validity       bool     // validity is true if the rules have validity windows.
+	softDelete     bool     // softDelete is true if removed rules are marked as deleted.
+	tenantMode     bool     // tenantMode is true if the rule operations are scoped to a tenant.
+	tenantID       string   // tenantID is the fixed tenant, or empty if it is taken from the context.
+	tenantTable    string   // tenantTable is the rule table name pattern of the tenants, or empty if the rule table is fixed.
+	tenantLogs     bool     // tenantLogs is true if the change log, audit and snapshot rows record their tenant.
+	instanceID     string   // instanceID identifies the changes made by this adapter in the change log.
+	valueColumns   []string // valueColumns are the v0..vn column names.
+	columnLength   int      // columnLength is the length of the ptype and value columns.
+	saveMode       SaveMode
+	loadPageSize   int           // loadPageSize is the number of rows read at once when loading rules.
+	replicaLag     time.Duration // replicaLag is the time after a change during which loads read the master, 0 if they always do.
+	state          *adapterState
 }
 
-func EnableCreateTable(enabled bool) {
-	EnabledCreate = enabled
+// adapterState is the state shared by an Adapter and its copies returned by WithTx.
+type adapterState struct {
+	view       atomic.Int32 // view is the policyView of the loaded rules.
+	lastChange atomic.Int64 // lastChange is the time of the latest change in Unix nanoseconds.
+	createMu   sync.Mutex   // createMu serializes the table creation.
+	tableReady bool         // tableReady is true once the table has been created or found.
+	tenantMu   sync.Mutex   // tenantMu serializes the creation of the tenant tables.
+	// tenantDaos caches the DAOs of the tenant tables created or found, by tenant.
+	tenantDaos map[string]*dao.CasbinRuleDao
 }
 
-func createTable(dbType string, tableName string) error {
-	if !EnabledCreate {
-		return nil
-	}
-	ctx := context.Background()
-	sql := GetCreateTableSQLByTemplate(dbType, tableName)
-	if sql == "" {
-		return errors.New("invalid db type")
-	}
-	_, err := g.DB().Exec(ctx, sql)
-	return err
+// policyView tells whether the loaded rules are all stored rules or a part of them.
+type policyView = int32
+
+const (
+	viewNone    policyView = iota // viewNone means no rules have been loaded yet.
+	viewFull                      // viewFull means all rules have been loaded.
+	viewPartial                   // viewPartial means filtered rules have been loaded.
+)
+
+// EnableCreateTable sets EnabledCreate.
+//
+// Deprecated: use NewAdapterWithOptions with WithAutoCreateTable, which is scoped to one adapter.
+func EnableCreateTable(enabled bool) {
+	EnabledCreate = enabled
 }
 
 // NewAdapter creates a new Adapter with default settings.
 // isFiltered is disabled by default.
+// The rule table is not checked, see WithSchemaCheck.
 
 func NewAdapter() (*Adapter, error) {
-	adapter := &Adapter{
-		dao:        dao.NewCasbinRuleDao(),
-		isFiltered: DisabledFiltered,
-	}
-	return adapter, nil
+	return NewAdapterWithOptions(
+		WithFiltered(DisabledFiltered),
+		WithSchemaCheck(false),
+	)
 }
 
 // NewAdapterWithFiltered creates a new Adapter with filtered enabled.
 // Table name is "casbin_rule" by default.
+// The rule table is not checked, see WithSchemaCheck.
 func NewAdapterWithFiltered() (*Adapter, error) {
-	adapter := &Adapter{
-		dao:        dao.NewCasbinRuleDao(),
-		isFiltered: EnabledFiltered,
-	}
-	err := createTable(g.DB().GetConfig().Type, adapter.dao.Table())
-	if err != nil {
-		return nil, err
-	}
-	return adapter, nil
+	return NewAdapterWithOptions(
+		WithFiltered(EnabledFiltered),
+		WithAutoCreateTable(EnabledCreate),
+		WithSchemaCheck(false),
+	)
 }
 
 // NewAdapterWithName creates a new Adapter with a custom table name.
+// The rule table is not checked, see WithSchemaCheck.
 func NewAdapterWithName(tableName string, isFiltered UserFiltered) (*Adapter, error) {
-	prefix := g.DB().GetConfig().Prefix
-	if prefix != "" && !strings.HasPrefix(tableName, prefix) {
-		tableName = prefix + tableName
+	return NewAdapterWithOptions(
+		WithTableName(tableName),
+		WithFiltered(isFiltered),
+		WithAutoCreateTable(EnabledCreate),
+		WithSchemaCheck(false),
+	)
+}
+
+// NewAdapterWithOptions creates a new Adapter configured by the given options.
+// The "default" database group and the "casbin_rule" table are used by default.
+func NewAdapterWithOptions(opts ...Option) (*Adapter, error) {
+	o := &options{
+		group:          gdb.DefaultGroupName,
+		tableName:      DefaultTableName,
+		migrationTable: DefaultMigrationTableName,
+		isFiltered:     DisabledFiltered,
+		valueColumns:   DefaultValueColumns,
+		columnLength:   DefaultColumnLength,
+		loadPageSize:   DefaultLoadPageSize,
+		schemaCheck:    true,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	db := o.db
+	if db == nil {
+		db = g.DB(o.group)
+	}
+
+	prefix := db.GetPrefix()
+	if o.prefix != nil {
+		prefix = *o.prefix
+	}
+	withPrefix := func(tableName string) string {
+		if prefix != "" && !strings.HasPrefix(tableName, prefix) {
+			return prefix + tableName
+		}
+		return tableName
+	}
+	tableName := withPrefix(o.tableName)
+
+	if o.valueColumns < DefaultValueColumns {
+		return nil, fmt.Errorf("at least %d value columns are required, got %d", DefaultValueColumns, o.valueColumns)
 	}
+	if o.columnLength <= 0 {
+		return nil, fmt.Errorf("invalid column length %d", o.columnLength)
+	}
+	if o.loadPageSize <= 0 {
+		return nil, fmt.Errorf("invalid load page size %d", o.loadPageSize)
+	}
+
 	adapter := &Adapter{
-		dao:        dao.NewCasbinRuleDaoWithName(tableName),
-		isFiltered: isFiltered,
+		isFiltered:     o.isFiltered,
+		migrationTable: withPrefix(o.migrationTable),
+		instanceID:     guid.S(),
+		columnLength:   o.columnLength,
+		saveMode:       o.saveMode,
+		loadPageSize:   o.loadPageSize,
+		replicaLag:     o.replicaLag,
+		validity:       o.validity,
+		softDelete:     o.softDelete,
+		tenantMode:     o.tenantMode,
+		tenantID:       o.tenantID,
+		tenantLogs:     o.tenantMode || o.tenantTables,
+		state:          &adapterState{},
 	}
-	err := createTable(g.DB().GetConfig().Type, tableName)
-	if err != nil {
-		return nil, err
+	if o.changeLogTable != "" {
+		adapter.changeLogTable = withPrefix(o.changeLogTable)
+	}
+	if o.auditTable != "" {
+		adapter.auditTable = withPrefix(o.auditTable)
+	}
+	if o.snapshotTable != "" {
+		adapter.snapshotTable = withPrefix(o.snapshotTable)
+	}
+	for i := 0; i < o.valueColumns; i++ {
+		adapter.valueColumns = append(adapter.valueColumns, fmt.Sprintf("v%d", i))
+	}
+	if o.db != nil {
+		adapter.dao = dao.NewCasbinRuleDaoWithDB(o.db, tableName, pinMaster)
+	} else {
+		adapter.dao = dao.NewCasbinRuleDaoWithGroup(o.group, tableName, pinMaster)
+	}
+	if adapter.tenantMode {
+		adapter.dao = adapter.dao.WithScope(adapter.tenantScope)
+	}
+	if o.tenantTables {
+		if o.tenantMode {
+			return nil, errors.New("WithTenantScope and WithTenantTables cannot be combined")
+		}
+		pattern := o.tenantTablePattern
+		if pattern == "" {
+			pattern = o.tableName + "_%s"
+		}
+		pattern = withPrefix(pattern)
+		if strings.Count(pattern, "%s") != 1 || strings.Count(pattern, "%") != 1 {
+			return nil, fmt.Errorf("invalid tenant table pattern %q, exactly one %%s is required", pattern)
+		}
+		adapter.tenantTable = pattern
+		adapter.dao = adapter.dao.WithScope(unresolvedTableScope)
+	}
+
+	if o.autoCreate {
+		if err := adapter.ensureTable(context.Background()); err != nil {
+			return nil, err
+		}
+	}
+	if o.schemaCheck {
+		if err := adapter.checkSchema(context.Background()); err != nil {
+			return nil, err
+		}
 	}
 	return adapter, nil
 }
 
+// WithTx returns a copy of the adapter whose reads and writes join the given transaction,
+// so the rules are committed or rolled back together with the other changes of the transaction.
+// The transaction must belong to the database of the adapter, it replaces a transaction carried by the context.
+// A transaction carried by the context, e.g. inside gdb.DB.Transaction, is joined without calling WithTx.
+func (a *Adapter) WithTx(tx gdb.TX) *Adapter {
+	c := *a
+	c.dao = a.dao.WithTX(tx)
+	return &c
+}
+
+// model returns a model of the given table reading the master and joining the transaction of the adapter or of ctx.
+func (a *Adapter) model(ctx context.Context, tableName string) *gdb.Model {
+	return a.dao.DB().Model(tableName).Master().Ctx(a.dao.TxCtx(ctx))
+}
+
 // LoadPolicy loads all policy rules from the storage.
 func (a *Adapter) LoadPolicy(model model.Model) error {
 	return a.LoadPolicyCtx(context.Background(), model)
@@ -136,21 +318,15 @@ func (a *Adapter) LoadPolicy(model model.Model) error {
 
 // LoadPolicyCtx loads policy from database.
 func (a *Adapter) LoadPolicyCtx(ctx context.Context, model model.Model) error {
-	var lines []entity.CasbinRule
-	cols := a.dao.Columns()
-	if err := a.dao.Ctx(ctx).Order(cols.Id).Scan(&lines); err != nil {
-		return err
-	}
-	err := a.preview(&lines, model)
+	a, err := a.forTenant(ctx)
 	if err != nil {
 		return err
 	}
-	for _, line := range lines {
-		err := loadPolicyLine(line, model)
-		if err != nil {
-			return err
-		}
+	ctx = a.withLoadNode(ctx)
+	if err = a.loadPolicy(ctx, model, a.policyQuery(ctx)); err != nil {
+		return err
 	}
+	a.state.view.Store(viewFull)
 	return nil
 }
 
@@ -160,51 +336,172 @@ func (a *Adapter) LoadFilteredPolicy(model model.Model, filter interface{}) erro
 }
 
 // LoadFilteredPolicyCtx loads only policy rules that match the filter.
+// The rules are added to the rules already in the model, see LoadIncrementalFilteredPolicyCtx.
 func (a *Adapter) LoadFilteredPolicyCtx(ctx context.Context, model model.Model, filter interface{}) error {
-	filterValue, ok := filter.(Filter)
-	if !ok {
-		return errors.New("invalid filter type")
-	}
+	return a.LoadIncrementalFilteredPolicyCtx(ctx, model, filter)
+}
 
-	cols := a.dao.Columns()
-	// Build query conditions
-	qs := a.dao.Ctx(ctx)
+// LoadIncrementalFilteredPolicy adds the policy rules that match the filter to the rules already in the model.
+func (a *Adapter) LoadIncrementalFilteredPolicy(model model.Model, filter interface{}) error {
+	return a.LoadIncrementalFilteredPolicyCtx(context.Background(), model, filter)
+}
 
-	// Apply filter conditions
-	a.applyFilter(qs, filterValue)
+// LoadIncrementalFilteredPolicyCtx adds the policy rules that match the filter to the rules already in the model.
+// Loading into an empty model makes the loaded rules filtered,
+// while adding rules to all loaded rules, e.g. by Enforcer.LoadIncrementalFilteredPolicy, keeps them complete.
+func (a *Adapter) LoadIncrementalFilteredPolicyCtx(ctx context.Context, model model.Model, filter interface{}) error {
+	a, err := a.forTenant(ctx)
+	if err != nil {
+		return err
+	}
+	filterValue, err := toFilter(filter)
+	if err != nil {
+		return err
+	}
+	empty := !hasPolicy(model)
 
-	// Execute query and sort by ID
-	var lines []entity.CasbinRule
-	if err := qs.Order(cols.Id).Scan(&lines); err != nil {
+	ctx = a.withLoadNode(ctx)
+	query, err := a.filterQuery(ctx, filterValue)
+	if err != nil {
+		return err
+	}
+	if err = a.loadPolicy(ctx, model, query); err != nil {
 		return err
 	}
+	a.markFiltered(empty)
+	return nil
+}
+
+// toFilter converts the filter given to the filtered loads to a Filter.
+func toFilter(filter interface{}) (Filter, error) {
+	switch f := filter.(type) {
+	case Filter:
+		return f, nil
+	case *Filter:
+		return *f, nil
+	case Cond:
+		return Filter{Cond: f}, nil
+	default:
+		return Filter{}, errors.New("invalid filter type")
+	}
+}
 
-	// Pre-check filter results to avoid duplicates
-	err := a.preview(&lines, model)
+// filterQuery returns the query selecting the valid rules that match the filter.
+func (a *Adapter) filterQuery(ctx context.Context, filter Filter) (func() *gdb.Model, error) {
+	where, args, err := a.buildCond(And(filter.Cond, sectionsCond(filter.Sections)))
 	if err != nil {
+		return nil, err
+	}
+	query := a.policyQuery(ctx)
+	return func() *gdb.Model {
+		// Build query conditions
+		qs := query()
+
+		// Apply filter conditions
+		a.applyFilter(qs, filter)
+		if where != "" {
+			qs = qs.Where(where, args...)
+		}
+		return qs
+	}, nil
+}
+
+// policyQuery returns the query selecting all valid rules.
+func (a *Adapter) policyQuery(ctx context.Context) func() *gdb.Model {
+	now := time.Now()
+	return func() *gdb.Model {
+		return a.whereValid(a.loadModel(ctx), now)
+	}
+}
+
+// markFiltered records that filtered rules have been loaded into a model that was empty before or not.
+func (a *Adapter) markFiltered(empty bool) {
+	if empty {
+		a.state.view.Store(viewPartial)
+	} else {
+		a.state.view.CompareAndSwap(viewNone, viewPartial)
+	}
+}
+
+// loadPolicy loads the rules selected by query into the model, reading loadPageSize rows at once.
+// The pages are read in one transaction, see readConsistent, so a rule changed meanwhile is not loaded twice.
+// If a page fails, the rules loaded from the previous pages are removed again,
+// so the model is only changed if all rules are loaded.
+func (a *Adapter) loadPolicy(ctx context.Context, model model.Model, query func() *gdb.Model) error {
+	fields := append([]string{a.dao.Columns().Id}, a.ruleFields()...)
+	var loaded [][]string
+	err := a.readConsistent(ctx, query, func(query func() *gdb.Model) error {
+		return a.eachPage(func() *gdb.Model {
+			return query().Fields(fields)
+		}, a.loadPageSize, func(result gdb.Result) error {
+			return a.addPolicyLines(model, a.policyLines(result), &loaded)
+		})
+	})
+	if err != nil {
+		unloadPolicyLines(model, loaded)
 		return err
 	}
+	return nil
+}
 
-	// Process query results
+// readPolicy returns the policy lines of the rules selected by query, reading loadPageSize rows at once.
+func (a *Adapter) readPolicy(ctx context.Context, query func() *gdb.Model) ([][]string, error) {
+	fields := append([]string{a.dao.Columns().Id}, a.ruleFields()...)
+	var lines [][]string
+	err := a.readConsistent(ctx, query, func(query func() *gdb.Model) error {
+		return a.eachPage(func() *gdb.Model {
+			return query().Fields(fields)
+		}, a.loadPageSize, func(result gdb.Result) error {
+			lines = append(lines, a.policyLines(result)...)
+			return nil
+		})
+	})
+	return lines, err
+}
+
+// addPolicyLines adds the lines missing from the model to it and appends the added lines to loaded.
+func (a *Adapter) addPolicyLines(model model.Model, lines [][]string, loaded *[][]string) error {
+	// Pre-check the lines to avoid duplicates
+	if err := a.preview(&lines, model); err != nil {
+		return err
+	}
 	for _, line := range lines {
-		err := loadPolicyLine(line, model)
-		if err != nil {
+		if err := loadPolicyLine(line, model); err != nil {
 			return err
 		}
+		*loaded = append(*loaded, line)
 	}
-
-	a.isFiltered = true
 	return nil
 }
 
 // IsFiltered returns true if the loaded policy has been filtered.
+// Before any rule is loaded, it returns the setting of WithFiltered, so the enforcer does not load all rules on creation.
 func (a *Adapter) IsFiltered() bool {
-	return bool(a.isFiltered)
+	switch a.state.view.Load() {
+	case viewFull:
+		return false
+	case viewPartial:
+		return true
+	default:
+		return bool(a.isFiltered)
+	}
 }
 
 // IsFilteredCtx returns true if the loaded policy has been filtered.
 func (a *Adapter) IsFilteredCtx(ctx context.Context) bool {
-	return bool(a.isFiltered)
+	return a.IsFiltered()
+}
+
+// hasPolicy reports whether the model holds any rule.
+func hasPolicy(model model.Model) bool {
+	for _, sec := range []string{"p", "g"} {
+		for _, ast := range model[sec] {
+			if len(ast.Policy) > 0 {
+				return true
+			}
+		}
+	}
+	return false
 }
 
 // SavePolicy saves all policy rules to the storage.
@@ -213,62 +510,202 @@ func (a *Adapter) SavePolicy(model model.Model) error {
 }
 
 // SavePolicyCtx saves policy to database.
+// Only the differences between the model and the stored rules are written, see WithSaveMode.
 func (a *Adapter) SavePolicyCtx(ctx context.Context, model model.Model) error {
-	var err error
-
-	tx, err := a.dao.DB().Begin(ctx)
+	a, err := a.forTenant(ctx)
 	if err != nil {
 		return err
 	}
-
-	// Truncate the table to ensure no duplicates
-	err = a.truncateTableWithTx(ctx, tx)
+	lines, err := a.modelPolicyLines(model)
 	if err != nil {
-		tx.Rollback()
 		return err
 	}
+	if a.saveMode == SaveModeTruncate {
+		return a.truncateAndSavePolicy(ctx, lines)
+	}
+	return a.dao.Transaction(ctx, func(ctx context.Context, tx gdb.TX) error {
+		removed, added, err := a.syncPolicyLines(ctx, tx, lines)
+		if err != nil {
+			return err
+		}
+		return a.recordChange(ctx, tx, change{op: ChangeSave, diff: true, removedLines: removed, addedLines: added})
+	})
+}
 
-	var lines []entity.CasbinRule
-	flushEvery := 1000
+// truncateAndSavePolicy empties the table and inserts the given lines.
+func (a *Adapter) truncateAndSavePolicy(ctx context.Context, lines []g.Map) error {
+	return a.dao.Transaction(ctx, func(ctx context.Context, tx gdb.TX) error {
+		// Truncate the table to ensure no duplicates
+		if err := a.truncateTableWithTx(ctx, tx); err != nil {
+			return err
+		}
+		if err := a.insertLines(ctx, tx, lines); err != nil {
+			return err
+		}
+		return a.recordChange(ctx, tx, change{op: ChangeSave})
+	})
+}
 
-	// Process p rules
-	for ptype, ast := range model["p"] {
-		for _, rule := range ast.Policy {
-			lines = append(lines, a.savePolicyLine(ptype, rule))
-			if len(lines) > flushEvery {
-				if _, err := tx.Model(a.dao.Table()).Ctx(ctx).Data(lines).InsertIgnore(); err != nil {
-					tx.Rollback()
-					return err
+// syncPolicyLines makes the stored rules equal to the given lines within tx.
+// Stored rules missing from the lines and duplicated rows are deleted, the lines not stored yet are inserted.
+// It returns the policy lines of the removed and the added rules.
+func (a *Adapter) syncPolicyLines(ctx context.Context, tx gdb.TX, lines []g.Map) (removed, added [][]string, err error) {
+	cols := a.dao.Columns()
+	// missing is true for the lines not found in the table yet, false for the lines found.
+	missing := make(map[string]bool, len(lines))
+	for _, line := range lines {
+		missing[gconv.String(line[cols.RuleHash])] = true
+	}
+
+	var stale []int64
+	removedHashes := make(map[string]bool)
+	// The rules outside their validity window are not in the model, they are kept.
+	now := time.Now()
+	query := func() *gdb.Model {
+		return a.whereValid(a.dao.Ctx(ctx).TX(tx), now).Fields(append([]string{cols.Id}, a.ruleFields()...))
+	}
+	err = a.eachPage(query, flushEvery, func(result gdb.Result) error {
+		for _, record := range result {
+			line := a.policyLine(record)
+			if len(line) > 0 {
+				hash := ruleHash(line[0], line[1:])
+				pending, ok := missing[hash]
+				if pending {
+					missing[hash] = false
+					continue
+				}
+				// Duplicated rows of a kept rule and of a rule removed already are not reported again.
+				if !ok && !removedHashes[hash] {
+					removedHashes[hash] = true
+					removed = append(removed, line)
 				}
-				lines = nil
 			}
+			stale = append(stale, record[cols.Id].Int64())
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for start := 0; start < len(stale); start += flushEvery {
+		end := min(start+flushEvery, len(stale))
+		if _, err = a.dao.Ctx(ctx).TX(tx).WhereIn(cols.Id, stale[start:end]).Delete(); err != nil {
+			return nil, nil, err
 		}
 	}
 
-	// Process g rules
-	for ptype, ast := range model["g"] {
-		for _, rule := range ast.Policy {
-			lines = append(lines, a.savePolicyLine(ptype, rule))
-			if len(lines) > flushEvery {
-				if _, err := tx.Model(a.dao.Table()).Ctx(ctx).Data(lines).InsertIgnore(); err != nil {
-					tx.Rollback()
-					return err
+	inserts := make([]g.Map, 0, len(lines))
+	for _, line := range lines {
+		if missing[gconv.String(line[cols.RuleHash])] {
+			inserts = append(inserts, line)
+			added = append(added, a.mapPolicyLine(line))
+		}
+	}
+	if inserts, err = a.reviveLines(ctx, tx, inserts); err != nil {
+		return nil, nil, err
+	}
+	if err = a.insertLines(ctx, tx, inserts); err != nil {
+		return nil, nil, err
+	}
+	return removed, added, nil
+}
+
+// modelPolicyLines returns the lines of all p and g rules of the model, without duplicates.
+func (a *Adapter) modelPolicyLines(model model.Model) ([]g.Map, error) {
+	var lines []g.Map
+	seen := make(map[string]bool)
+	// Process p and g rules
+	for _, sec := range []string{"p", "g"} {
+		for ptype, ast := range model[sec] {
+			for _, rule := range ast.Policy {
+				line, err := a.savePolicyLine(ptype, rule)
+				if err != nil {
+					return nil, err
+				}
+				hash := gconv.String(line[a.dao.Columns().RuleHash])
+				if seen[hash] {
+					continue
 				}
-				lines = nil
+				seen[hash] = true
+				lines = append(lines, line)
 			}
 		}
 	}
+	return lines, nil
+}
+
+// flushEvery is the number of rows written by one statement.
+const flushEvery = 1000
 
-	// Insert remaining lines
-	if len(lines) > 0 {
-		if _, err := tx.Model(a.dao.Table()).Ctx(ctx).Data(lines).InsertIgnore(); err != nil {
-			tx.Rollback()
+// insertLines inserts the lines within tx in batches.
+func (a *Adapter) insertLines(ctx context.Context, tx gdb.TX, lines []g.Map) error {
+	for start := 0; start < len(lines); start += flushEvery {
+		end := min(start+flushEvery, len(lines))
+		if _, err := a.dao.Ctx(ctx).TX(tx).Data(lines[start:end]).InsertIgnore(); err != nil {
 			return err
 		}
 	}
+	return nil
+}
+
+// readConsistent calls f with a query reading the rows of query in a read-only transaction,
+// so the statements of a paged read all see the same rows, e.g. not both the old and the new rule of an update.
+// The transaction is started on the node selected by ctx, see withLoadNode.
+// A transaction of the adapter or of ctx is used as it is.
+func (a *Adapter) readConsistent(ctx context.Context, query func() *gdb.Model, f func(query func() *gdb.Model) error) error {
+	db := a.dao.DB()
+	opts, ok := consistentReadOptions(db.GetConfig().Type)
+	if !ok || a.dao.TX() != nil || gdb.TXFromCtx(ctx, db.GetGroup()) != nil {
+		return f(query)
+	}
+	link, err := db.Master()
+	if readNode(ctx) == ReadNodeReplica {
+		link, err = db.Slave()
+	}
+	if err != nil {
+		return err
+	}
+	// gdb begins transactions on the master only, so the transaction is begun on the link directly.
+	out, err := db.DoCommit(ctx, gdb.DoCommitInput{
+		Db:            link,
+		Sql:           "BEGIN",
+		Type:          gdb.SqlTypeBegin,
+		TxOptions:     opts,
+		IsTransaction: true,
+	})
+	if err != nil {
+		return err
+	}
+	// Nothing is written, so the transaction is rolled back in any case.
+	defer out.Tx.Rollback()
+	return f(func() *gdb.Model {
+		return query().TX(out.Tx)
+	})
+}
 
-	// Commit the transaction
-	return tx.Commit()
+// eachPage reads the rows selected by query in pages of pageSize rows ordered by id and calls f for every page.
+// The pages are read by id rather than by offset, so each page is found through the primary key.
+// query must return a new model selecting the id on every call.
+func (a *Adapter) eachPage(query func() *gdb.Model, pageSize int, f func(result gdb.Result) error) error {
+	cols := a.dao.Columns()
+	var last int64
+	for {
+		result, err := query().WhereGT(cols.Id, last).Order(cols.Id).Limit(pageSize).All()
+		if err != nil {
+			return err
+		}
+		if len(result) == 0 {
+			return nil
+		}
+		if err = f(result); err != nil {
+			return err
+		}
+		if len(result) < pageSize {
+			return nil
+		}
+		last = result[len(result)-1][cols.Id].Int64()
+	}
 }
 
 // AddPolicy adds a policy rule to the storage.
@@ -278,10 +715,9 @@ func (a *Adapter) AddPolicy(sec string, ptype string, rule []string) error {
 }
 
 // AddPolicyCtx adds a policy rule to the storage.
+// It returns a *RuleExistsError if the rule already exists.
 func (a *Adapter) AddPolicyCtx(ctx context.Context, sec string, ptype string, rule []string) error {
-	line := a.savePolicyLine(ptype, rule)
-	_, err := a.dao.Ctx(ctx).Data(line).InsertIgnore()
-	return err
+	return a.AddPoliciesCtx(ctx, sec, ptype, [][]string{rule})
 }
 
 // AddPolicies adds policy rules to the storage.
@@ -292,13 +728,30 @@ func (a *Adapter) AddPolicies(sec string, ptype string, rules [][]string) error
 
 // AddPoliciesCtx adds policy rules to the storage.
 // This is part of the Auto-Save feature.
+// No rule is added and a *RuleExistsError listing the existing rules is returned if any rule already exists.
 func (a *Adapter) AddPoliciesCtx(ctx context.Context, sec string, ptype string, rules [][]string) error {
-	var lines []entity.CasbinRule
-	for _, rule := range rules {
-		line := a.savePolicyLine(ptype, rule)
-		lines = append(lines, line)
+	a, err := a.forTenant(ctx)
+	if err != nil {
+		return err
+	}
+	lines, err := a.savePolicyLines(ptype, rules)
+	if err != nil {
+		return err
+	}
+	err = a.dao.Transaction(ctx, func(ctx context.Context, tx gdb.TX) error {
+		if err := a.insertPolicyLines(ctx, tx, ptype, lines); err != nil {
+			return err
+		}
+		return a.recordChange(ctx, tx, change{op: ChangeAdd, sec: sec, ptype: ptype, rules: rules})
+	})
+	if errors.Is(err, errConcurrentInsert) {
+		// The rules added concurrently are only visible once the transaction is over.
+		existing, err := a.existingRules(ctx, nil, lines)
+		if err != nil {
+			return err
+		}
+		return &RuleExistsError{Ptype: ptype, Rules: existing}
 	}
-	_, err := a.dao.Ctx(ctx).Data(lines).InsertIgnore()
 	return err
 }
 
@@ -311,11 +764,20 @@ func (a *Adapter) RemovePolicy(sec string, ptype string, rule []string) error {
 // RemovePolicyCtx removes a policy rule from the storage with context.
 // This is part of the Auto-Save feature.
 func (a *Adapter) RemovePolicyCtx(ctx context.Context, sec string, ptype string, rule []string) error {
-	line := a.savePolicyLine(ptype, rule)
-	_, err := a.dao.Ctx(ctx).Where(line).OmitEmpty().Delete()
+	_, err := a.RemovePolicyAffected(ctx, sec, ptype, rule)
 	return err
 }
 
+// RemovePolicyAffected removes a policy rule from the storage and returns the number of removed rows.
+// Only the row matching all values exactly is removed, empty values included.
+func (a *Adapter) RemovePolicyAffected(ctx context.Context, sec string, ptype string, rule []string) (int64, error) {
+	a, err := a.forTenant(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return a.removePolicies(ctx, sec, ptype, [][]string{rule})
+}
+
 // RemovePolicies removes policy rules from the storage.
 // This is part of the Auto-Save feature.
 func (a *Adapter) RemovePolicies(sec string, ptype string, rules [][]string) error {
@@ -325,17 +787,52 @@ func (a *Adapter) RemovePolicies(sec string, ptype string, rules [][]string) err
 // RemovePoliciesCtx removes policy rules from the storage.
 // This is part of the Auto-Save feature.
 func (a *Adapter) RemovePoliciesCtx(ctx context.Context, sec string, ptype string, rules [][]string) error {
-	err := a.dao.DB().Transaction(ctx, func(ctx context.Context, tx gdb.TX) error {
-		for _, rule := range rules {
-			line := a.savePolicyLine(ptype, rule)
-			_, err := tx.Model(a.dao.Table()).Ctx(ctx).Where(line).OmitEmpty().Delete()
+	_, err := a.RemovePoliciesAffected(ctx, sec, ptype, rules)
+	return err
+}
+
+// RemovePoliciesAffected removes policy rules from the storage and returns the number of removed rows.
+// Only the rows matching all values of a rule exactly are removed, empty values included.
+func (a *Adapter) RemovePoliciesAffected(ctx context.Context, sec string, ptype string, rules [][]string) (int64, error) {
+	a, err := a.forTenant(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return a.removePolicies(ctx, sec, ptype, rules)
+}
+
+// removePolicies removes the rules like RemovePoliciesAffected, on the rule table of the tenant resolved by forTenant.
+func (a *Adapter) removePolicies(ctx context.Context, sec string, ptype string, rules [][]string) (int64, error) {
+	lines, err := a.savePolicyLines(ptype, rules)
+	if err != nil {
+		return 0, err
+	}
+	var affected int64
+	err = a.dao.Transaction(ctx, func(ctx context.Context, tx gdb.TX) error {
+		var removed [][]string
+		for i, line := range lines {
+			result, err := a.dao.Ctx(ctx).TX(tx).Where(a.ruleCondition(line)).Delete()
 			if err != nil {
 				return err
 			}
+			n, err := result.RowsAffected()
+			if err != nil {
+				return err
+			}
+			if n > 0 {
+				removed = append(removed, rules[i])
+			}
+			affected += n
 		}
-		return nil
+		if len(removed) == 0 {
+			return nil
+		}
+		return a.recordChange(ctx, tx, change{op: ChangeRemove, sec: sec, ptype: ptype, rules: removed})
 	})
-	return err
+	if err != nil {
+		return 0, err
+	}
+	return affected, nil
 }
 
 // RemoveFilteredPolicy removes policy rules that match the filter from the storage.
@@ -347,34 +844,36 @@ func (a *Adapter) RemoveFilteredPolicy(sec string, ptype string, fieldIndex int,
 // RemoveFilteredPolicyCtx removes policy rules that match the filter from the storage with context.
 // This is part of the Auto-Save feature.
 func (a *Adapter) RemoveFilteredPolicyCtx(ctx context.Context, sec string, ptype string, fieldIndex int, fieldValues ...string) error {
-	line := &entity.CasbinRule{}
-	line.Ptype = ptype
-
-	// If fieldIndex is -1, delete all policies with the specified ptype
-	if fieldIndex == -1 {
-		_, err := a.dao.Ctx(ctx).Where(line).OmitEmpty().Delete()
-		return err
-	}
-
-	// Check if all query fields are empty
-	err := a.checkQueryField(fieldValues)
+	a, err := a.forTenant(ctx)
 	if err != nil {
 		return err
 	}
+	// If fieldIndex is -1, delete all policies with the specified ptype
+	line := g.Map{a.dao.Columns().Ptype: ptype}
+	omitEmpty := false
+	if fieldIndex != -1 {
+		// Check if all query fields are empty
+		err := a.checkQueryField(fieldValues)
+		if err != nil {
+			return err
+		}
 
-	// Set filter conditions based on fieldIndex and fieldValues
-	fields := []*string{&line.V0, &line.V1, &line.V2, &line.V3, &line.V4, &line.V5}
-	idx := fieldIndex + len(fieldValues)
-
-	for i := 0; i < len(fields); i++ {
-		if fieldIndex <= i && i < idx {
-			*fields[i] = fieldValues[i-fieldIndex]
+		// Set filter conditions based on fieldIndex and fieldValues
+		line, err = a.filteredPolicyLine(ptype, fieldIndex, fieldValues)
+		if err != nil {
+			return err
 		}
+		omitEmpty = true
 	}
 
 	// Execute delete operation
-	_, err = a.dao.Ctx(ctx).Where(line).OmitEmpty().Delete()
-	return err
+	return a.dao.Transaction(ctx, func(ctx context.Context, tx gdb.TX) error {
+		removed, err := a.deleteMatching(ctx, tx, line, omitEmpty)
+		if err != nil || len(removed) == 0 {
+			return err
+		}
+		return a.recordChange(ctx, tx, change{op: ChangeRemove, sec: sec, ptype: ptype, rules: a.ruleValues(removed)})
+	})
 }
 
 // This is part of the Auto-Save feature.
@@ -385,12 +884,58 @@ func (a *Adapter) UpdatePolicy(sec string, ptype string, oldRule, newRule []stri
 // UpdatePolicyCtx updates a policy rule from storage.
 // This is part of the Auto-Save feature.
 func (a *Adapter) UpdatePolicyCtx(ctx context.Context, sec string, ptype string, oldRule, newRule []string) error {
-	oldLine := a.savePolicyLine(ptype, oldRule)
-	newLine := a.savePolicyLine(ptype, newRule)
-	_, err := a.dao.Ctx(ctx).Where(oldLine).OmitEmpty().Data(newLine).Update()
+	_, err := a.UpdatePolicyAffected(ctx, sec, ptype, oldRule, newRule)
 	return err
 }
 
+// UpdatePolicyAffected updates a policy rule from storage and returns the number of updated rows.
+// Only the row matching all values of the old rule exactly is updated, empty values included.
+// A *RuleExistsError is returned if the new rule already exists.
+func (a *Adapter) UpdatePolicyAffected(ctx context.Context, sec string, ptype string, oldRule, newRule []string) (int64, error) {
+	a, err := a.forTenant(ctx)
+	if err != nil {
+		return 0, err
+	}
+	oldLine, err := a.savePolicyLine(ptype, oldRule)
+	if err != nil {
+		return 0, err
+	}
+	newLine, err := a.savePolicyLine(ptype, newRule)
+	if err != nil {
+		return 0, err
+	}
+	var affected int64
+	err = a.dao.Transaction(ctx, func(ctx context.Context, tx gdb.TX) error {
+		result, err := a.replaceLine(ctx, tx, oldLine, newLine)
+		if err != nil || result == nil {
+			return err
+		}
+		if affected, err = result.RowsAffected(); err != nil || affected == 0 {
+			return err
+		}
+		return a.recordChange(ctx, tx, change{
+			op:       ChangeUpdate,
+			sec:      sec,
+			ptype:    ptype,
+			rules:    [][]string{oldRule},
+			newRules: [][]string{newRule},
+		})
+	})
+	hashColumn := a.dao.Columns().RuleHash
+	var existsErr *RuleExistsError
+	if err != nil && !errors.As(err, &existsErr) && newLine[hashColumn] != oldLine[hashColumn] {
+		// A new rule added concurrently makes the update violate the unique rule hash,
+		// it is only visible once the transaction is over.
+		if existing, _ := a.existingRules(ctx, nil, []g.Map{newLine}); len(existing) > 0 {
+			return 0, &RuleExistsError{Ptype: ptype, Rules: existing}
+		}
+	}
+	if err != nil {
+		return 0, err
+	}
+	return affected, nil
+}
+
 // UpdatePolicies updates some policy rules to storage, like db, redis.
 func (a *Adapter) UpdatePolicies(sec string, ptype string, oldRules, newRules [][]string) error {
 	return a.UpdatePoliciesCtx(context.Background(), sec, ptype, oldRules, newRules)
@@ -398,54 +943,89 @@ func (a *Adapter) UpdatePolicies(sec string, ptype string, oldRules, newRules []
 
 // UpdatePoliciesCtx updates some policy rules to storage, like db, redis.
 func (a *Adapter) UpdatePoliciesCtx(ctx context.Context, sec string, ptype string, oldRules, newRules [][]string) error {
-	tx, err := a.dao.DB().Begin(ctx)
+	_, err := a.UpdatePoliciesAffected(ctx, sec, ptype, oldRules, newRules)
+	return err
+}
+
+// UpdatePoliciesAffected updates some policy rules to storage and returns the number of replaced rows.
+// Only the rows matching all values of an old rule exactly are replaced, empty values included.
+// The new rule of an old rule without rows is not added, and only the replaced rules are recorded.
+func (a *Adapter) UpdatePoliciesAffected(ctx context.Context, sec string, ptype string, oldRules, newRules [][]string) (int64, error) {
+	a, err := a.forTenant(ctx)
 	if err != nil {
-		return err
+		return 0, err
 	}
-
-	oldP := make([]entity.CasbinRule, 0, len(oldRules))
-	for _, oldRule := range oldRules {
-		oldP = append(oldP, a.savePolicyLine(ptype, oldRule))
+	if len(oldRules) != len(newRules) {
+		return 0, fmt.Errorf("the number of old rules %d differs from the number of new rules %d", len(oldRules), len(newRules))
 	}
-
-	newP := make([]entity.CasbinRule, 0, len(newRules))
-	for _, newRule := range newRules {
-		newP = append(newP, a.savePolicyLine(ptype, newRule))
+	oldP, err := a.savePolicyLines(ptype, oldRules)
+	if err != nil {
+		return 0, err
+	}
+	newP, err := a.savePolicyLines(ptype, newRules)
+	if err != nil {
+		return 0, err
 	}
 
 	cols := a.dao.Columns()
-
-	// Batch delete old policies - first query IDs, then batch delete
-	if len(oldP) > 0 {
-		var idsToDelete []int64
-		for _, oldLine := range oldP {
-			var ruleIds []int64
-			var arr []gdb.Value
-			if arr, err = tx.Model(a.dao.Table()).Ctx(ctx).Where(oldLine).OmitEmpty().Array(cols.Id); err != nil {
-				tx.Rollback()
+	var affected int64
+	err = a.dao.Transaction(ctx, func(ctx context.Context, tx gdb.TX) error {
+		// Find the rows of every old rule first, then delete them in one batch
+		var (
+			idsToDelete        []int64
+			replaced, replaces [][]string
+			newLines           []g.Map
+		)
+		seen := make(map[int64]bool)
+		for i, oldLine := range oldP {
+			arr, err := a.dao.Ctx(ctx).TX(tx).Where(a.ruleCondition(oldLine)).Array(cols.Id)
+			if err != nil {
 				return err
 			}
-			ruleIds = gconv.Int64s(arr)
-			idsToDelete = append(idsToDelete, ruleIds...)
+			var ids []int64
+			for _, id := range gconv.Int64s(arr) {
+				if !seen[id] {
+					seen[id] = true
+					ids = append(ids, id)
+				}
+			}
+			if len(ids) == 0 {
+				continue
+			}
+			idsToDelete = append(idsToDelete, ids...)
+			replaced = append(replaced, oldRules[i])
+			replaces = append(replaces, newRules[i])
+			newLines = append(newLines, newP[i])
+		}
+		if len(idsToDelete) == 0 {
+			return nil
 		}
 
 		// Batch delete using IDs
-		if len(idsToDelete) > 0 {
-			if _, err := tx.Model(a.dao.Table()).Ctx(ctx).WhereIn(cols.Id, idsToDelete).Delete(); err != nil {
-				tx.Rollback()
-				return err
-			}
+		result, err := a.dao.Ctx(ctx).TX(tx).WhereIn(cols.Id, idsToDelete).Delete()
+		if err != nil {
+			return err
+		}
+		if affected, err = result.RowsAffected(); err != nil {
+			return err
 		}
-	}
 
-	// Then add new policies
-	if len(newP) > 0 {
-		if _, err := tx.Model(a.dao.Table()).Ctx(ctx).Data(newP).InsertIgnore(); err != nil {
-			tx.Rollback()
+		// Then add the new rules of the replaced ones
+		inserts, err := a.reviveLines(ctx, tx, newLines)
+		if err != nil {
 			return err
 		}
+		if len(inserts) > 0 {
+			if _, err := a.dao.Ctx(ctx).TX(tx).Data(inserts).InsertIgnore(); err != nil {
+				return err
+			}
+		}
+		return a.recordChange(ctx, tx, change{op: ChangeUpdate, sec: sec, ptype: ptype, rules: replaced, newRules: replaces})
+	})
+	if err != nil {
+		return 0, err
 	}
-	return tx.Commit()
+	return affected, nil
 }
 
 // UpdateFilteredPolicies deletes old rules and adds new rules.
@@ -455,67 +1035,102 @@ func (a *Adapter) UpdateFilteredPolicies(sec string, ptype string, newRules [][]
 
 // UpdateFilteredPoliciesCtx deletes old rules and adds new rules.
 func (a *Adapter) UpdateFilteredPoliciesCtx(ctx context.Context, sec string, ptype string, newRules [][]string, fieldIndex int, fieldValues ...string) ([][]string, error) {
+	a, err := a.forTenant(ctx)
+	if err != nil {
+		return nil, err
+	}
 	// Build filter conditions
-	line := &entity.CasbinRule{}
-	line.Ptype = ptype
-
-	// Set filter conditions based on fieldIndex and fieldValues
-	fields := []*string{&line.V0, &line.V1, &line.V2, &line.V3, &line.V4, &line.V5}
-	idx := fieldIndex + len(fieldValues)
-
-	for i := 0; i < len(fields); i++ {
-		if fieldIndex <= i && i < idx {
-			*fields[i] = fieldValues[i-fieldIndex]
-		}
+	line, err := a.filteredPolicyLine(ptype, fieldIndex, fieldValues)
+	if err != nil {
+		return nil, err
 	}
 
 	// Prepare new policy data
-	newP := make([]entity.CasbinRule, 0, len(newRules))
-	for _, newRule := range newRules {
-		newP = append(newP, a.savePolicyLine(ptype, newRule))
-	}
-
-	// Begin transaction
-	tx, err := a.dao.DB().Begin(ctx)
+	newP, err := a.savePolicyLines(ptype, newRules)
 	if err != nil {
 		return nil, err
 	}
 
-	// Query old policies to be deleted
-	var oldP []entity.CasbinRule
-	if err := tx.Model(a.dao.Table()).Ctx(ctx).Where(line).OmitEmpty().Scan(&oldP); err != nil {
-		tx.Rollback()
-		return nil, err
-	}
+	var oldPolicies [][]string
+	err = a.dao.Transaction(ctx, func(ctx context.Context, tx gdb.TX) error {
+		// Delete old policies
+		oldP, err := a.deleteMatching(ctx, tx, line, true)
+		if err != nil {
+			return err
+		}
 
-	// Delete old policies
-	if _, err := tx.Model(a.dao.Table()).Ctx(ctx).Where(line).OmitEmpty().Delete(); err != nil {
-		tx.Rollback()
+		// Batch add new policies
+		inserts, err := a.reviveLines(ctx, tx, newP)
+		if err != nil {
+			return err
+		}
+		if len(inserts) > 0 {
+			if _, err := a.dao.Ctx(ctx).TX(tx).Data(inserts).InsertIgnore(); err != nil {
+				return err
+			}
+		}
+
+		// Build list of deleted policies to return
+		oldPolicies = make([][]string, 0)
+		for _, v := range oldP {
+			oldPolicy := a.toStringPolicy(v)
+			oldPolicies = append(oldPolicies, oldPolicy)
+		}
+
+		return a.recordChange(ctx, tx, change{op: ChangeUpdate, sec: sec, ptype: ptype, rules: a.ruleValues(oldP), newRules: newRules})
+	})
+	if err != nil {
 		return nil, err
 	}
+	return oldPolicies, nil
+}
 
-	// Batch add new policies
-	if len(newP) > 0 {
-		if _, err := tx.Model(a.dao.Table()).Ctx(ctx).Data(newP).InsertIgnore(); err != nil {
-			tx.Rollback()
+// replaceLine replaces the rows of the old line by the new line within tx.
+// It returns a nil result if no row of the old line exists.
+func (a *Adapter) replaceLine(ctx context.Context, tx gdb.TX, oldLine, newLine g.Map) (sql.Result, error) {
+	condition := a.ruleCondition(oldLine)
+	if hidden, _ := a.hiddenCondition(time.Now()); hidden != "" {
+		// A hidden row of the new rule would make the update violate the unique rule hash,
+		// so it is made visible again and the old rows are deleted instead.
+		count, err := a.dao.Ctx(ctx).TX(tx).Where(condition).Count()
+		if err != nil || count == 0 {
 			return nil, err
 		}
+		remaining, err := a.reviveLines(ctx, tx, []g.Map{newLine})
+		if err != nil {
+			return nil, err
+		}
+		if len(remaining) == 0 {
+			return a.dao.Ctx(ctx).TX(tx).Where(condition).Delete()
+		}
 	}
-
-	// Build list of deleted policies to return
-	oldPolicies := make([][]string, 0)
-	for _, v := range oldP {
-		oldPolicy := a.toStringPolicy(v)
-		oldPolicies = append(oldPolicies, oldPolicy)
+	cols := a.dao.Columns()
+	if newLine[cols.RuleHash] != oldLine[cols.RuleHash] {
+		// Updating to a visible rule would violate the unique rule hash as well.
+		existing, err := a.existingRules(ctx, tx, []g.Map{newLine})
+		if err != nil {
+			return nil, err
+		}
+		if len(existing) > 0 {
+			count, err := a.dao.Ctx(ctx).TX(tx).Where(condition).Count()
+			if err != nil || count == 0 {
+				return nil, err
+			}
+			return nil, &RuleExistsError{Ptype: gconv.String(newLine[cols.Ptype]), Rules: existing}
+		}
 	}
-
-	return oldPolicies, tx.Commit()
+	return a.dao.Ctx(ctx).TX(tx).Where(condition).Data(newLine).Update()
 }
 
 // truncateTableWithTx clears the table within a transaction
 func (a *Adapter) truncateTableWithTx(ctx context.Context, tx gdb.TX) error {
+	// Truncating would delete the rules of all tenants.
+	if a.tenantMode {
+		_, err := a.dao.Ctx(ctx).TX(tx).Unscoped().Delete()
+		return err
+	}
 	tableName := a.dao.Table()
-	dbType := a.dao.DB().GetConfig().Type
+	dbType := tx.GetDB().GetConfig().Type
 
 	// mysql, mariadb, sqlite, mssql, pgsql, oracle, clickhouse, dm.
 	var sql string
@@ -538,26 +1153,48 @@ func (a *Adapter) truncateTableWithTx(ctx context.Context, tx gdb.TX) error {
 }
 
 // loadPolicyLine loads policy line
-func loadPolicyLine(line entity.CasbinRule, model model.Model) error {
-	var p = []string{line.Ptype,
-		line.V0, line.V1, line.V2,
-		line.V3, line.V4, line.V5}
-	p = p[:findLastNonEmptyIndex(p)]
-	err := persist.LoadPolicyArray(p, model)
+func loadPolicyLine(line []string, model model.Model) error {
+	err := persist.LoadPolicyArray(line, model)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
+// unloadPolicyLines removes the given lines from the model.
+// The rules of a ptype are filtered at once, which is faster than removing the lines one by one.
+func unloadPolicyLines(m model.Model, lines [][]string) {
+	removed := make(map[string]map[string]bool)
+	for _, line := range lines {
+		ptype := line[0]
+		if removed[ptype] == nil {
+			removed[ptype] = make(map[string]bool)
+		}
+		removed[ptype][strings.Join(line[1:], model.DefaultSep)] = true
+	}
+	for ptype, keys := range removed {
+		ast, ok := m[ptype[:1]][ptype]
+		if !ok {
+			continue
+		}
+		policy := ast.Policy[:0]
+		for _, rule := range ast.Policy {
+			if !keys[strings.Join(rule, model.DefaultSep)] {
+				policy = append(policy, rule)
+			}
+		}
+		ast.Policy = policy
+		ast.PolicyMap = make(map[string]int, len(policy))
+		for i, rule := range policy {
+			ast.PolicyMap[strings.Join(rule, model.DefaultSep)] = i
+		}
+	}
+}
+
 // preview Pre-checking to avoid causing partial load success and partial failure deep
-func (a *Adapter) preview(rules *[]entity.CasbinRule, model model.Model) error {
+func (a *Adapter) preview(lines *[][]string, model model.Model) error {
 	j := 0
-	for i, rule := range *rules {
-		r := []string{rule.Ptype,
-			rule.V0, rule.V1, rule.V2,
-			rule.V3, rule.V4, rule.V5}
-		p := r[:findLastNonEmptyIndex(r)]
+	for i, p := range *lines {
 		key := p[0]
 		sec := key[:1]
 		ok, err := model.HasPolicyEx(sec, key, p[1:])
@@ -565,27 +1202,208 @@ func (a *Adapter) preview(rules *[]entity.CasbinRule, model model.Model) error {
 			return err
 		}
 		if ok {
-			(*rules)[j], (*rules)[i] = rule, (*rules)[j]
+			(*lines)[j], (*lines)[i] = p, (*lines)[j]
 			j++
 		}
 	}
-	(*rules) = (*rules)[j:]
+	(*lines) = (*lines)[j:]
 	return nil
 }
 
-func (a *Adapter) savePolicyLine(ptype string, rule []string) entity.CasbinRule {
-	line := &entity.CasbinRule{
-		Ptype: ptype,
+// ruleFields returns the ptype and value columns of the table.
+func (a *Adapter) ruleFields() []string {
+	return append([]string{a.dao.Columns().Ptype}, a.valueColumns...)
+}
+
+// policyLines converts query results to policy lines, the ptype followed by the values without trailing empty values.
+func (a *Adapter) policyLines(result gdb.Result) [][]string {
+	lines := make([][]string, 0, len(result))
+	for _, record := range result {
+		lines = append(lines, a.policyLine(record))
+	}
+	return lines
+}
+
+// policyLine converts a record to a policy line.
+func (a *Adapter) policyLine(record gdb.Record) []string {
+	line := make([]string, 0, len(a.valueColumns)+1)
+	line = append(line, record[a.dao.Columns().Ptype].String())
+	for _, column := range a.valueColumns {
+		line = append(line, record[column].String())
+	}
+	return line[:findLastNonEmptyIndex(line)]
+}
+
+// mapPolicyLine converts the data of a row made by savePolicyLine back to a policy line.
+func (a *Adapter) mapPolicyLine(line g.Map) []string {
+	policyLine := make([]string, 0, len(a.valueColumns)+1)
+	policyLine = append(policyLine, gconv.String(line[a.dao.Columns().Ptype]))
+	for _, column := range a.valueColumns {
+		policyLine = append(policyLine, gconv.String(line[column]))
 	}
+	return policyLine[:findLastNonEmptyIndex(policyLine)]
+}
 
-	fields := []*string{&line.V0, &line.V1, &line.V2, &line.V3, &line.V4, &line.V5}
-	for i, field := range fields {
+// savePolicyLine converts a rule to the data of a row.
+// It returns an error if the rule has more values than value columns or a value exceeds the column length.
+func (a *Adapter) savePolicyLine(ptype string, rule []string) (g.Map, error) {
+	if len(rule) > len(a.valueColumns) {
+		return nil, fmt.Errorf("the rule has %d values but the table only has %d value columns: %v", len(rule), len(a.valueColumns), rule)
+	}
+	line := g.Map{
+		a.dao.Columns().Ptype: ptype,
+	}
+	for i, column := range a.valueColumns {
+		value := ""
 		if i < len(rule) {
-			*field = rule[i]
+			value = rule[i]
+		}
+		if utf8.RuneCountInString(value) > a.columnLength {
+			return nil, fmt.Errorf("the value of %s exceeds the column length %d: %v", column, a.columnLength, rule)
+		}
+		line[column] = value
+	}
+	line[a.dao.Columns().RuleHash] = ruleHash(ptype, rule)
+	return line, nil
+}
+
+// savePolicyLines converts rules to the data of rows.
+func (a *Adapter) savePolicyLines(ptype string, rules [][]string) ([]g.Map, error) {
+	lines := make([]g.Map, 0, len(rules))
+	for _, rule := range rules {
+		line, err := a.savePolicyLine(ptype, rule)
+		if err != nil {
+			return nil, err
+		}
+		lines = append(lines, line)
+	}
+	return lines, nil
+}
+
+// filteredPolicyLine returns the condition of the ptype and the field values starting at fieldIndex.
+func (a *Adapter) filteredPolicyLine(ptype string, fieldIndex int, fieldValues []string) (g.Map, error) {
+	if fieldIndex < 0 || fieldIndex+len(fieldValues) > len(a.valueColumns) {
+		return nil, fmt.Errorf("the field index %d with %d values is out of the %d value columns", fieldIndex, len(fieldValues), len(a.valueColumns))
+	}
+	line := g.Map{
+		a.dao.Columns().Ptype: ptype,
+	}
+	for i, value := range fieldValues {
+		line[a.valueColumns[fieldIndex+i]] = value
+	}
+	return line, nil
+}
+
+// insertPolicyLines inserts the rows of a ptype within a transaction.
+// Rows of the same rule are inserted once. If any rule already exists, a *RuleExistsError is returned.
+func (a *Adapter) insertPolicyLines(ctx context.Context, tx gdb.TX, ptype string, lines []g.Map) error {
+	hashColumn := a.dao.Columns().RuleHash
+	seen := make(map[interface{}]bool, len(lines))
+	unique := lines[:0:0]
+	for _, line := range lines {
+		if !seen[line[hashColumn]] {
+			seen[line[hashColumn]] = true
+			unique = append(unique, line)
+		}
+	}
+	if len(unique) == 0 {
+		return nil
+	}
+
+	existing, err := a.existingRules(ctx, tx, unique)
+	if err != nil {
+		return err
+	}
+	if len(existing) > 0 {
+		return &RuleExistsError{Ptype: ptype, Rules: existing}
+	}
+	if unique, err = a.reviveLines(ctx, tx, unique); err != nil || len(unique) == 0 {
+		return err
+	}
+
+	result, err := a.dao.Ctx(ctx).TX(tx).Data(unique).InsertIgnore()
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected < int64(len(unique)) {
+		return errConcurrentInsert
+	}
+	return nil
+}
+
+// existingRules returns the rules of the given rows that exist in the table.
+func (a *Adapter) existingRules(ctx context.Context, tx gdb.TX, lines []g.Map) ([][]string, error) {
+	const chunkSize = 100
+	var existing [][]string
+	for start := 0; start < len(lines); start += chunkSize {
+		end := start + chunkSize
+		if end > len(lines) {
+			end = len(lines)
+		}
+		m := a.whereValid(a.dao.Ctx(ctx), time.Now())
+		if tx != nil {
+			m = m.TX(tx)
+		}
+		builder := m.Builder()
+		for _, line := range lines[start:end] {
+			builder = builder.WhereOr(a.ruleCondition(line))
+		}
+		result, err := m.Fields(a.ruleFields()).Where(builder).All()
+		if err != nil {
+			return nil, err
+		}
+		for _, record := range result {
+			existing = append(existing, a.policyLine(record)[1:])
+		}
+	}
+	return existing, nil
+}
+
+// ruleValues returns the values of the rules in result, without ptype.
+func (a *Adapter) ruleValues(result gdb.Result) [][]string {
+	rules := make([][]string, 0, len(result))
+	for _, record := range result {
+		rules = append(rules, a.policyLine(record)[1:])
+	}
+	return rules
+}
+
+// deleteMatching deletes the rows matching the condition within tx and returns them.
+// Empty values of the condition are ignored if omitEmpty is set.
+func (a *Adapter) deleteMatching(ctx context.Context, tx gdb.TX, condition g.Map, omitEmpty bool) (gdb.Result, error) {
+	cols := a.dao.Columns()
+	m := a.dao.Ctx(ctx).TX(tx).Fields(append([]string{cols.Id}, a.ruleFields()...)).Where(condition)
+	if omitEmpty {
+		m = m.OmitEmpty()
+	}
+	result, err := m.Order(cols.Id).All()
+	if err != nil || len(result) == 0 {
+		return nil, err
+	}
+	// The rows are deleted by id, so exactly the returned rows are deleted.
+	ids := gconv.Int64s(result.Array(cols.Id))
+	for start := 0; start < len(ids); start += flushEvery {
+		end := min(start+flushEvery, len(ids))
+		if _, err = a.dao.Ctx(ctx).TX(tx).WhereIn(cols.Id, ids[start:end]).Delete(); err != nil {
+			return nil, err
 		}
 	}
+	return result, nil
+}
 
-	return *line
+// ruleCondition returns the condition matching the ptype and all value columns of a row exactly.
+func (a *Adapter) ruleCondition(line g.Map) g.Map {
+	condition := g.Map{
+		a.dao.Columns().Ptype: line[a.dao.Columns().Ptype],
+	}
+	for _, column := range a.valueColumns {
+		condition[column] = line[column]
+	}
+	return condition
 }
 
 // checkQueryField ensures that query fields are not all empty strings
@@ -598,9 +1416,9 @@ func (a *Adapter) checkQueryField(fieldValues []string) error {
 	return errors.New("the query field cannot all be empty string (\"\"), please check")
 }
 
-// toStringPolicy converts CasbinRule to string policy array
-func (a *Adapter) toStringPolicy(c entity.CasbinRule) []string {
-	fields := []string{c.Ptype, c.V0, c.V1, c.V2, c.V3, c.V4, c.V5}
+// toStringPolicy converts a record to string policy array
+func (a *Adapter) toStringPolicy(record gdb.Record) []string {
+	fields := a.policyLine(record)
 	policy := make([]string, 0, len(fields))
 
 	for _, field := range fields {
@@ -611,6 +1429,20 @@ func (a *Adapter) toStringPolicy(c entity.CasbinRule) []string {
 	return policy
 }
 
+// buildCond returns the SQL of the condition with its arguments for the database of the adapter.
+func (a *Adapter) buildCond(cond Cond) (string, []interface{}, error) {
+	db := a.dao.DB()
+	b := &condBuilder{
+		dbType:  db.GetConfig().Type,
+		quote:   db.GetCore().QuoteWord,
+		columns: make(map[string]bool),
+	}
+	for _, column := range a.ruleFields() {
+		b.columns[column] = true
+	}
+	return cond.build(b)
+}
+
 // applyFilter applies filter conditions to the query by modifying the original query object
 func (a *Adapter) applyFilter(qs *gdb.Model, filter Filter) {
 	cols := a.dao.Columns()
@@ -631,6 +1463,18 @@ func (a *Adapter) applyFilter(qs *gdb.Model, filter Filter) {
 	}
 }
 
+// ruleHash returns the hex SHA-256 hash identifying a rule, trailing empty values are ignored.
+func ruleHash(ptype string, rule []string) string {
+	line := append([]string{ptype}, rule...)
+	line = line[:findLastNonEmptyIndex(line)]
+	h := sha256.New()
+	for _, field := range line {
+		// Length prefixes keep the encoding unambiguous whatever the values contain.
+		fmt.Fprintf(h, "%d:%s", len(field), field)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
 // findLastNonEmptyIndex finds the last non-empty index in a slice of strings
 func findLastNonEmptyIndex(fields []string) int {
 	for i := len(fields) - 1; i >= 0; i-- {
@@ -640,3 +1484,74 @@ func findLastNonEmptyIndex(fields []string) int {
 	}
 	return 0
 }
+
+// hiddenCondition returns the condition matching the stored rows that are not loaded,
+// as they are soft deleted or outside their validity window at now. It returns "" if no row can be hidden.
+func (a *Adapter) hiddenCondition(now time.Time) (string, []interface{}) {
+	var (
+		conds []string
+		args  []interface{}
+	)
+	if a.softDelete {
+		conds = append(conds, a.quote(deletedAtColumn.name)+" IS NOT NULL")
+	}
+	if a.validity {
+		conds = append(conds, fmt.Sprintf("%s > ? OR %s <= ?", a.quote(validFromColumn.name), a.quote(validUntilColumn.name)))
+		args = append(args, now, now)
+	}
+	if len(conds) == 0 {
+		return "", nil
+	}
+	return "(" + strings.Join(conds, " OR ") + ")", args
+}
+
+// reviveLines makes the hidden stored rules of the lines visible again, with the validity window of the line,
+// and returns the lines of the rules that are not stored.
+// The unique rule hash would reject the lines of these rules otherwise.
+func (a *Adapter) reviveLines(ctx context.Context, tx gdb.TX, lines []g.Map) ([]g.Map, error) {
+	hidden, args := a.hiddenCondition(time.Now())
+	if hidden == "" || len(lines) == 0 {
+		return lines, nil
+	}
+	hashColumn := a.dao.Columns().RuleHash
+
+	stored := make(map[string]bool)
+	for start := 0; start < len(lines); start += flushEvery {
+		end := min(start+flushEvery, len(lines))
+		hashes := make([]string, 0, end-start)
+		for _, line := range lines[start:end] {
+			hashes = append(hashes, gconv.String(line[hashColumn]))
+		}
+		values, err := a.dao.Ctx(ctx).TX(tx).Unscoped().
+			Fields(hashColumn).
+			WhereIn(hashColumn, hashes).
+			Where(hidden, args...).
+			Array()
+		if err != nil {
+			return nil, err
+		}
+		for _, hash := range gconv.Strings(values) {
+			stored[hash] = true
+		}
+	}
+
+	remaining := lines[:0:0]
+	for _, line := range lines {
+		if !stored[gconv.String(line[hashColumn])] {
+			remaining = append(remaining, line)
+			continue
+		}
+		data := g.Map{}
+		if a.softDelete {
+			data[deletedAtColumn.name] = nil
+		}
+		if a.validity {
+			data[validFromColumn.name] = line[validFromColumn.name]
+			data[validUntilColumn.name] = line[validUntilColumn.name]
+		}
+		if _, err := a.dao.Ctx(ctx).TX(tx).Unscoped().Data(data).Where(hashColumn, line[hashColumn]).Update(); err != nil {
+			return nil, err
+		}
+	}
+	return remaining, nil
+}
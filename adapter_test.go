@@ -1,13 +1,19 @@
 package gfadapter
 
 import (
+	"context"
+	"errors"
 	"log"
 	"testing"
 
 	"github.com/casbin/casbin/v2"
 	"github.com/casbin/casbin/v2/util"
+	"github.com/gogf/gf/v2/database/gdb"
+	"github.com/gogf/gf/v2/frame/g"
 	"github.com/stretchr/testify/assert"
 
+	"github.com/yclw/gf-casbin-adapter/dao"
+
 	_ "github.com/gogf/gf/contrib/drivers/mysql/v2"
 )
 
@@ -319,3 +325,295 @@ func TestAddPolicy(t *testing.T) {
 		t.Errorf("e2.AddPolicy() got true, want false (policy already exists)")
 	}
 }
+
+func TestAdapterWithOptions(t *testing.T) {
+	a, err := NewAdapterWithOptions(
+		WithGroup("default"),
+		WithTableName("casbin_rule"),
+		WithFiltered(DisabledFiltered),
+	)
+	assert.Nil(t, err)
+	assert.Equal(t, "default", a.dao.Group())
+
+	initPolicy(t, a)
+	testAutoSave(t, a)
+	testSaveLoad(t, a)
+}
+
+func TestAutoCreateTableTwice(t *testing.T) {
+	opts := []Option{WithTableName("test_casbin_rule_create"), WithAutoCreateTable(true)}
+
+	a, err := NewAdapterWithOptions(opts...)
+	assert.Nil(t, err)
+	// The table already exists, so the second adapter must only check it.
+	_, err = NewAdapterWithOptions(opts...)
+	assert.Nil(t, err)
+
+	initPolicy(t, a)
+}
+
+func TestSavePolicyLine(t *testing.T) {
+	a := &Adapter{
+		dao:          dao.NewCasbinRuleDao(),
+		valueColumns: []string{"v0", "v1", "v2", "v3", "v4", "v5", "v6"},
+		columnLength: 10,
+	}
+
+	line, err := a.savePolicyLine("p", []string{"alice", "data1", "read", "", "", "", "allow"})
+	assert.Nil(t, err)
+	assert.Equal(t, "allow", line["v6"])
+	assert.Equal(t, "", line["v3"])
+
+	_, err = a.savePolicyLine("p", []string{"a", "b", "c", "d", "e", "f", "g", "h"})
+	assert.NotNil(t, err)
+
+	_, err = a.savePolicyLine("p", []string{"alice", "/api/orders/1"})
+	assert.NotNil(t, err)
+}
+
+func TestRuleHash(t *testing.T) {
+	assert.Equal(t, ruleHash("p", []string{"alice", "data1"}), ruleHash("p", []string{"alice", "data1", "", ""}))
+	assert.NotEqual(t, ruleHash("p", []string{"alice", "data1"}), ruleHash("p", []string{"alice", "", "data1"}))
+	assert.NotEqual(t, ruleHash("p", []string{"ab", "c"}), ruleHash("p", []string{"a", "bc"}))
+	assert.Len(t, ruleHash("g", []string{"alice", "admin"}), 64)
+}
+
+func TestAddExistingPolicy(t *testing.T) {
+	a := initAdapter(t)
+
+	err := a.AddPolicy("p", "p", []string{"alice", "data1", "read"})
+	assert.True(t, errors.Is(err, ErrRuleExists))
+
+	// Nothing is added if any rule already exists.
+	err = a.AddPolicies("p", "p", [][]string{{"carol", "data3", "read"}, {"bob", "data2", "write"}})
+	var existsErr *RuleExistsError
+	assert.True(t, errors.As(err, &existsErr))
+	assert.Equal(t, [][]string{{"bob", "data2", "write"}}, existsErr.Rules)
+
+	e, _ := casbin.NewEnforcer("examples/rbac_model.conf", a)
+	testGetPolicy(t, e, [][]string{{"alice", "data1", "read"}, {"bob", "data2", "write"}, {"data2_admin", "data2", "read"}, {"data2_admin", "data2", "write"}})
+}
+
+func TestUpdateToExistingPolicy(t *testing.T) {
+	a := initAdapter(t)
+	ctx := context.Background()
+
+	// Updating a rule to another existing rule must not touch either.
+	affected, err := a.UpdatePolicyAffected(ctx, "p", "p", []string{"alice", "data1", "read"}, []string{"bob", "data2", "write"})
+	var existsErr *RuleExistsError
+	assert.True(t, errors.As(err, &existsErr))
+	assert.True(t, errors.Is(err, ErrRuleExists))
+	assert.Equal(t, "p", existsErr.Ptype)
+	assert.Equal(t, [][]string{{"bob", "data2", "write"}}, existsErr.Rules)
+	assert.Equal(t, int64(0), affected)
+
+	// Nothing is reported if the old rule does not exist.
+	affected, err = a.UpdatePolicyAffected(ctx, "p", "p", []string{"nobody", "data1", "read"}, []string{"bob", "data2", "write"})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), affected)
+
+	e, _ := casbin.NewEnforcer("examples/rbac_model.conf", a)
+	testGetPolicy(t, e, [][]string{{"alice", "data1", "read"}, {"bob", "data2", "write"}, {"data2_admin", "data2", "read"}, {"data2_admin", "data2", "write"}})
+}
+
+func TestRemovePolicyExactMatch(t *testing.T) {
+	a := initAdapter(t)
+	ctx := context.Background()
+
+	assert.Nil(t, a.AddPolicies("p", "p", [][]string{{"", "data1", "write"}, {"alice", "data1", "write"}}))
+
+	// The empty subject must not match alice's rule.
+	affected, err := a.RemovePolicyAffected(ctx, "p", "p", []string{"", "data1", "write"})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), affected)
+
+	affected, err = a.RemovePolicyAffected(ctx, "p", "p", []string{"", "data1", "write"})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), affected)
+
+	// A shorter rule must not match rules with more values.
+	affected, err = a.RemovePoliciesAffected(ctx, "p", "p", [][]string{{"alice", "data1"}})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), affected)
+
+	affected, err = a.UpdatePolicyAffected(ctx, "p", "p", []string{"alice", "data1", "write"}, []string{"alice", "data3", "write"})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), affected)
+
+	affected, err = a.UpdatePoliciesAffected(ctx, "p", "p", [][]string{{"nobody", "data1", "read"}}, [][]string{{"nobody", "data1", "write"}})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), affected)
+
+	e, _ := casbin.NewEnforcer("examples/rbac_model.conf", a)
+	ok, err := e.HasPolicy("alice", "data1", "read")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+}
+
+func TestUpdatePoliciesPartialMatch(t *testing.T) {
+	a, err := NewAdapterWithOptions(
+		WithTableName("test_casbin_rule_update_partial"),
+		WithAutoCreateTable(true),
+		WithAuditLog("test_casbin_rule_update_partial_audit"),
+	)
+	assert.Nil(t, err)
+	initPolicy(t, a)
+	ctx := context.Background()
+	latest, err := a.AuditLog(ctx, AuditQuery{Limit: 1})
+	assert.Nil(t, err)
+	assert.Len(t, latest, 1)
+
+	// Only the rules that exist are replaced and recorded.
+	affected, err := a.UpdatePoliciesAffected(ctx, "p", "p",
+		[][]string{{"nobody", "data1", "read"}, {"alice", "data1", "read"}},
+		[][]string{{"nobody", "data1", "write"}, {"alice", "data1", "write"}},
+	)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), affected)
+	entries, err := a.AuditLog(ctx, AuditQuery{Limit: 2})
+	assert.Nil(t, err)
+	assert.Len(t, entries, 2)
+	assert.Equal(t, latest[0].ID, entries[1].ID)
+	assert.Equal(t, []string{"alice", "data1", "read"}, entries[0].OldRule)
+	assert.Equal(t, []string{"alice", "data1", "write"}, entries[0].NewRule)
+
+	// Nothing is recorded if no rule matches.
+	affected, err = a.UpdatePoliciesAffected(ctx, "p", "p", [][]string{{"nobody", "data1", "read"}}, [][]string{{"nobody", "data1", "write"}})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), affected)
+	again, err := a.AuditLog(ctx, AuditQuery{Limit: 1})
+	assert.Nil(t, err)
+	assert.Equal(t, entries[:1], again)
+
+	e, err := casbin.NewEnforcer("examples/rbac_model.conf", a)
+	assert.Nil(t, err)
+	testGetPolicy(t, e, [][]string{{"alice", "data1", "write"}, {"bob", "data2", "write"}, {"data2_admin", "data2", "read"}, {"data2_admin", "data2", "write"}})
+
+	_, err = a.UpdatePoliciesAffected(ctx, "p", "p", [][]string{{"alice", "data1", "write"}}, nil)
+	assert.NotNil(t, err)
+}
+
+func TestSavePolicyDiff(t *testing.T) {
+	a := initAdapter(t)
+	ctx := context.Background()
+	cols := a.dao.Columns()
+
+	id, err := a.dao.Ctx(ctx).Where(cols.V0, "bob").Value(cols.Id)
+	assert.Nil(t, err)
+
+	e, _ := casbin.NewEnforcer("examples/rbac_model.conf", a)
+	_, err = e.RemovePolicy("alice", "data1", "read")
+	assert.Nil(t, err)
+	e.EnableAutoSave(false)
+	_, err = e.AddPolicy("carol", "data3", "read")
+	assert.Nil(t, err)
+	_, err = e.RemovePolicy("data2_admin", "data2", "read")
+	assert.Nil(t, err)
+	assert.Nil(t, e.SavePolicy())
+
+	// The unchanged rule keeps its row.
+	newID, err := a.dao.Ctx(ctx).Where(cols.V0, "bob").Value(cols.Id)
+	assert.Nil(t, err)
+	assert.Equal(t, id.Int64(), newID.Int64())
+
+	assert.Nil(t, e.LoadPolicy())
+	testGetPolicy(t, e, [][]string{{"bob", "data2", "write"}, {"carol", "data3", "read"}, {"data2_admin", "data2", "write"}})
+}
+
+func TestSavePolicyTruncate(t *testing.T) {
+	a, err := NewAdapterWithOptions(WithSaveMode(SaveModeTruncate))
+	assert.Nil(t, err)
+	initPolicy(t, a)
+	testSaveLoad(t, a)
+}
+
+func TestUnloadPolicyLines(t *testing.T) {
+	e, err := casbin.NewEnforcer("examples/rbac_model.conf", "examples/rbac_policy.csv")
+	assert.Nil(t, err)
+	unloadPolicyLines(e.GetModel(), [][]string{{"p", "alice", "data1", "read"}, {"g", "alice", "data2_admin"}})
+	testGetPolicy(t, e, [][]string{{"bob", "data2", "write"}, {"data2_admin", "data2", "read"}, {"data2_admin", "data2", "write"}})
+	ok, err := e.HasPolicy("data2_admin", "data2", "write")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	grouping, _ := e.GetGroupingPolicy()
+	assert.Empty(t, grouping)
+}
+
+func TestLoadPolicyPaged(t *testing.T) {
+	a, err := NewAdapterWithOptions(
+		WithTableName("test_casbin_rule_paged"),
+		WithAutoCreateTable(true),
+		WithLoadPageSize(2),
+	)
+	assert.Nil(t, err)
+	initPolicy(t, a)
+	testSaveLoad(t, a)
+
+	// A rule with a missing value fails on the last page, the rules of the previous pages are removed again.
+	_, err = a.dao.Ctx(context.Background()).Data(g.Map{"ptype": "p", "v0": "carol", "v1": "data3"}).Insert()
+	assert.Nil(t, err)
+	e, err := casbin.NewEnforcer("examples/rbac_model.conf")
+	assert.Nil(t, err)
+	assert.NotNil(t, a.LoadPolicy(e.GetModel()))
+	testGetPolicy(t, e, [][]string{})
+}
+
+func TestLoadPolicyConsistent(t *testing.T) {
+	a := initAdapter(t)
+	ctx := context.Background()
+
+	// An update between two pages is not seen by the second page.
+	var pages [][]string
+	err := a.readConsistent(ctx, a.policyQuery(ctx), func(query func() *gdb.Model) error {
+		result, err := query().Order("id").Limit(1).All()
+		if err != nil {
+			return err
+		}
+		pages = append(pages, a.policyLines(result)...)
+		if err = a.UpdatePolicy("p", "p", []string{"data2_admin", "data2", "write"}, []string{"data2_admin", "data3", "write"}); err != nil {
+			return err
+		}
+		result, err = query().Order("id").Limit(1, 10).All()
+		pages = append(pages, a.policyLines(result)...)
+		return err
+	})
+	assert.Nil(t, err)
+	assert.Contains(t, pages, []string{"p", "data2_admin", "data2", "write"})
+	assert.NotContains(t, pages, []string{"p", "data2_admin", "data3", "write"})
+
+	lines, err := a.readPolicy(ctx, a.policyQuery(ctx))
+	assert.Nil(t, err)
+	assert.Contains(t, lines, []string{"p", "data2_admin", "data3", "write"})
+	assert.NotContains(t, lines, []string{"p", "data2_admin", "data2", "write"})
+}
+
+func TestTransaction(t *testing.T) {
+	a := initAdapter(t)
+	ctx := context.Background()
+	rolledBack := errors.New("rolled back")
+
+	// A transaction carried by the context is joined.
+	err := a.dao.DB().Transaction(ctx, func(ctx context.Context, tx gdb.TX) error {
+		assert.Nil(t, a.AddPolicyCtx(ctx, "p", "p", []string{"carol", "data3", "read"}))
+		assert.Nil(t, a.RemovePolicyCtx(ctx, "p", "p", []string{"bob", "data2", "write"}))
+		return rolledBack
+	})
+	assert.Equal(t, rolledBack, err)
+
+	// WithTx joins the given transaction.
+	tx, err := a.dao.DB().Begin(ctx)
+	assert.Nil(t, err)
+	assert.Nil(t, a.WithTx(tx).AddPolicyCtx(ctx, "p", "p", []string{"dave", "data3", "read"}))
+	assert.Nil(t, tx.Rollback())
+
+	testSaveLoad(t, a)
+
+	tx, err = a.dao.DB().Begin(ctx)
+	assert.Nil(t, err)
+	assert.Nil(t, a.WithTx(tx).AddPolicyCtx(ctx, "p", "p", []string{"dave", "data3", "read"}))
+	assert.Nil(t, tx.Commit())
+	e, _ := casbin.NewEnforcer("examples/rbac_model.conf", a)
+	ok, err := e.HasPolicy("dave", "data3", "read")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+}
@@ -0,0 +1,242 @@
+package gfadapter
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/gogf/gf/v2/database/gdb"
+	"github.com/gogf/gf/v2/frame/g"
+)
+
+// DefaultAuditTableName is the name of the audit table used when WithAuditLog is given an empty name.
+const DefaultAuditTableName = "casbin_rule_audit"
+
+// DefaultAuditPageSize is the number of entries returned by AuditLog if the query sets no limit.
+const DefaultAuditPageSize = 100
+
+// errAuditLogDisabled is returned by AuditLog for an adapter created without WithAuditLog.
+var errAuditLogDisabled = errors.New("the audit log is not enabled, create the adapter with WithAuditLog")
+
+// auditContextKey is the type of the context keys of the audit log.
+type auditContextKey int
+
+const (
+	auditActorKey auditContextKey = iota
+	auditReasonKey
+)
+
+// WithAuditActor returns a context whose changes are recorded in the audit log as made by actor.
+func WithAuditActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, auditActorKey, actor)
+}
+
+// WithAuditReason returns a context whose changes are recorded in the audit log with reason.
+func WithAuditReason(ctx context.Context, reason string) context.Context {
+	return context.WithValue(ctx, auditReasonKey, reason)
+}
+
+// AuditEntry is the change of one rule recorded in the audit log.
+// OldRule is empty for an added rule and NewRule is empty for a removed rule.
+// A SavePolicy in SaveModeTruncate is recorded as one entry without rules.
+type AuditEntry struct {
+	ID        int64
+	Op        string // Op is ChangeAdd, ChangeRemove, ChangeUpdate or ChangeSave.
+	Ptype     string
+	OldRule   []string
+	NewRule   []string
+	Actor     string
+	Reason    string
+	CreatedAt time.Time
+}
+
+// AuditQuery selects the entries returned by AuditLog.
+// Its zero value selects the latest entries.
+type AuditQuery struct {
+	Ptype    string    // Ptype selects the entries of one ptype if not empty.
+	Actor    string    // Actor selects the entries of one actor if not empty.
+	Since    time.Time // Since selects the entries recorded at or after it if not zero.
+	Until    time.Time // Until selects the entries recorded before it if not zero.
+	BeforeID int64     // BeforeID selects the entries older than the entry with this ID, to read the next page.
+	Limit    int       // Limit is the maximum number of entries, DefaultAuditPageSize is used if not positive.
+}
+
+// auditTableDef returns the definition of the audit table.
+func auditTableDef(tableName string, columnLength int) tableDef {
+	return tableDef{
+		name: tableName,
+		columns: []columnDef{
+			{name: "id", kind: kindID},
+			{name: "op", kind: kindString, size: 16},
+			{name: "ptype", kind: kindString, size: columnLength},
+			{name: "old_rule", kind: kindText},
+			{name: "new_rule", kind: kindText},
+			{name: "actor", kind: kindString, size: columnLength},
+			{name: "reason", kind: kindText},
+			{name: "created_at", kind: kindTime},
+		},
+		indexes: []indexDef{
+			{name: "idx_created_at", columns: []string{"created_at"}},
+			{name: "idx_actor", columns: []string{"actor"}},
+		},
+		comment: "Casbin rule audit log",
+	}
+}
+
+// auditRule is the change of one rule, an empty rule is encoded as NULL.
+type auditRule struct {
+	ptype   string
+	oldRule []string
+	newRule []string
+}
+
+// auditRules returns the changes of the single rules of c.
+func auditRules(c change) []auditRule {
+	var rules []auditRule
+	switch c.op {
+	case ChangeAdd:
+		for _, rule := range c.rules {
+			rules = append(rules, auditRule{ptype: c.ptype, newRule: rule})
+		}
+	case ChangeRemove:
+		for _, rule := range c.rules {
+			rules = append(rules, auditRule{ptype: c.ptype, oldRule: rule})
+		}
+	case ChangeUpdate:
+		// The rules of UpdateFilteredPolicies are not replaced one by one.
+		if len(c.rules) == len(c.newRules) {
+			for i := range c.rules {
+				rules = append(rules, auditRule{ptype: c.ptype, oldRule: c.rules[i], newRule: c.newRules[i]})
+			}
+			break
+		}
+		for _, rule := range c.rules {
+			rules = append(rules, auditRule{ptype: c.ptype, oldRule: rule})
+		}
+		for _, rule := range c.newRules {
+			rules = append(rules, auditRule{ptype: c.ptype, newRule: rule})
+		}
+	case ChangeSave:
+		if !c.diff {
+			return []auditRule{{}}
+		}
+		for _, line := range c.removedLines {
+			rules = append(rules, auditRule{ptype: line[0], oldRule: line[1:]})
+		}
+		for _, line := range c.addedLines {
+			rules = append(rules, auditRule{ptype: line[0], newRule: line[1:]})
+		}
+	}
+	return rules
+}
+
+// recordAudit writes the rules changed by c to the audit log within tx, with the actor and reason of ctx.
+// It does nothing if the audit log is disabled.
+func (a *Adapter) recordAudit(ctx context.Context, tx gdb.TX, c change) error {
+	if a.auditTable == "" {
+		return nil
+	}
+	rules := auditRules(c)
+	if len(rules) == 0 {
+		return nil
+	}
+	actor, _ := ctx.Value(auditActorKey).(string)
+	reason, _ := ctx.Value(auditReasonKey).(string)
+	now := time.Now()
+	data := make([]g.Map, 0, len(rules))
+	for _, rule := range rules {
+		row := g.Map{
+			"op":         c.op,
+			"ptype":      rule.ptype,
+			"actor":      actor,
+			"reason":     reason,
+			"created_at": now,
+		}
+		for column, values := range map[string][]string{"old_rule": rule.oldRule, "new_rule": rule.newRule} {
+			if values == nil {
+				continue
+			}
+			encoded, err := json.Marshal(values)
+			if err != nil {
+				return err
+			}
+			row[column] = string(encoded)
+		}
+		data = append(data, row)
+	}
+	for start := 0; start < len(data); start += flushEvery {
+		end := min(start+flushEvery, len(data))
+		m := a.logModel(ctx, a.auditTable)
+		if tx != nil {
+			m = m.TX(tx)
+		}
+		if _, err := m.Data(data[start:end]).Insert(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AuditLog returns the entries of the audit log selected by q, the latest first.
+// The next page is read by setting q.BeforeID to the ID of the last returned entry.
+func (a *Adapter) AuditLog(ctx context.Context, q AuditQuery) ([]AuditEntry, error) {
+	if a.auditTable == "" {
+		return nil, errAuditLogDisabled
+	}
+	limit := q.Limit
+	if limit <= 0 {
+		limit = DefaultAuditPageSize
+	}
+	m := a.logModel(ctx, a.auditTable)
+	if q.Ptype != "" {
+		m = m.Where("ptype", q.Ptype)
+	}
+	if q.Actor != "" {
+		m = m.Where("actor", q.Actor)
+	}
+	if !q.Since.IsZero() {
+		m = m.WhereGTE("created_at", q.Since)
+	}
+	if !q.Until.IsZero() {
+		m = m.WhereLT("created_at", q.Until)
+	}
+	if q.BeforeID > 0 {
+		m = m.WhereLT("id", q.BeforeID)
+	}
+	result, err := m.OrderDesc("id").Limit(limit).All()
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]AuditEntry, 0, len(result))
+	for _, record := range result {
+		entry := AuditEntry{
+			ID:        record["id"].Int64(),
+			Op:        record["op"].String(),
+			Ptype:     record["ptype"].String(),
+			Actor:     record["actor"].String(),
+			Reason:    record["reason"].String(),
+			CreatedAt: record["created_at"].Time(),
+		}
+		if entry.OldRule, err = decodeRule(record["old_rule"].String()); err != nil {
+			return nil, err
+		}
+		if entry.NewRule, err = decodeRule(record["new_rule"].String()); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// decodeRule decodes a rule encoded by recordAudit.
+func decodeRule(s string) ([]string, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var rule []string
+	if err := json.Unmarshal([]byte(s), &rule); err != nil {
+		return nil, err
+	}
+	return rule, nil
+}
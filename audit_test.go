@@ -0,0 +1,166 @@
+package gfadapter
+
+import (
+	"context"
+	"testing"
+
+	"github.com/casbin/casbin/v2/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuditRules(t *testing.T) {
+	assert.Equal(t, []auditRule{
+		{ptype: "p", oldRule: []string{"alice", "data1", "read"}, newRule: []string{"alice", "data1", "write"}},
+	}, auditRules(change{
+		op:       ChangeUpdate,
+		ptype:    "p",
+		rules:    [][]string{{"alice", "data1", "read"}},
+		newRules: [][]string{{"alice", "data1", "write"}},
+	}))
+	assert.Equal(t, []auditRule{
+		{ptype: "p", oldRule: []string{"alice", "data1", "read"}},
+		{ptype: "p", newRule: []string{"bob", "data1", "read"}},
+		{ptype: "p", newRule: []string{"bob", "data2", "read"}},
+	}, auditRules(change{
+		op:       ChangeUpdate,
+		ptype:    "p",
+		rules:    [][]string{{"alice", "data1", "read"}},
+		newRules: [][]string{{"bob", "data1", "read"}, {"bob", "data2", "read"}},
+	}))
+	assert.Equal(t, []auditRule{
+		{ptype: "g", oldRule: []string{"alice", "admin"}},
+		{ptype: "p", newRule: []string{"carol", "data3", "read"}},
+	}, auditRules(change{
+		op:           ChangeSave,
+		diff:         true,
+		removedLines: [][]string{{"g", "alice", "admin"}},
+		addedLines:   [][]string{{"p", "carol", "data3", "read"}},
+	}))
+	assert.Empty(t, auditRules(change{op: ChangeSave, diff: true}))
+	assert.Equal(t, []auditRule{{}}, auditRules(change{op: ChangeSave}))
+	assert.Empty(t, auditRules(change{op: ChangeReload}))
+}
+
+// auditEntriesAfter returns the entries of the audit log after the entry start, the latest first.
+func auditEntriesAfter(t *testing.T, a *Adapter, start int64) []AuditEntry {
+	var entries []AuditEntry
+	q := AuditQuery{Limit: 2}
+	for {
+		page, err := a.AuditLog(context.Background(), q)
+		assert.Nil(t, err)
+		for _, entry := range page {
+			if entry.ID <= start {
+				return entries
+			}
+			entries = append(entries, entry)
+		}
+		if len(page) < q.Limit {
+			return entries
+		}
+		q.BeforeID = page[len(page)-1].ID
+	}
+}
+
+// latestAuditEntry returns the ID of the latest entry of the audit log, or 0 if it is empty.
+func latestAuditEntry(t *testing.T, a *Adapter) int64 {
+	latest, err := a.AuditLog(context.Background(), AuditQuery{Limit: 1})
+	assert.Nil(t, err)
+	if len(latest) == 0 {
+		return 0
+	}
+	return latest[0].ID
+}
+
+func TestAuditLog(t *testing.T) {
+	a, err := NewAdapterWithOptions(
+		WithTableName("test_casbin_rule_audited"),
+		WithAutoCreateTable(true),
+		WithAuditLog("test_casbin_rule_audit"),
+	)
+	assert.Nil(t, err)
+	initPolicy(t, a)
+	ctx := WithAuditReason(WithAuditActor(context.Background(), "admin"), "ticket 42")
+	start := latestAuditEntry(t, a)
+
+	assert.Nil(t, a.AddPolicyCtx(ctx, "p", "p", []string{"carol", "data3", "read"}))
+	assert.Nil(t, a.UpdatePolicyCtx(ctx, "p", "p", []string{"bob", "data2", "write"}, []string{"bob", "data3", "write"}))
+	assert.Nil(t, a.RemoveFilteredPolicyCtx(ctx, "g", "g", 0, "alice"))
+
+	m, err := model.NewModelFromFile("examples/rbac_model.conf")
+	assert.Nil(t, err)
+	assert.Nil(t, a.LoadPolicyCtx(ctx, m))
+	_, err = m.RemovePoliciesWithAffected("p", "p", [][]string{{"carol", "data3", "read"}})
+	assert.Nil(t, err)
+	assert.Nil(t, a.SavePolicyCtx(context.Background(), m))
+
+	entries := auditEntriesAfter(t, a, start)
+	assert.Len(t, entries, 4)
+
+	assert.Equal(t, ChangeSave, entries[0].Op)
+	assert.Equal(t, []string{"carol", "data3", "read"}, entries[0].OldRule)
+	assert.Empty(t, entries[0].Actor)
+
+	assert.Equal(t, ChangeRemove, entries[1].Op)
+	assert.Equal(t, "g", entries[1].Ptype)
+	assert.Equal(t, []string{"alice", "data2_admin"}, entries[1].OldRule)
+	assert.Nil(t, entries[1].NewRule)
+
+	assert.Equal(t, ChangeUpdate, entries[2].Op)
+	assert.Equal(t, []string{"bob", "data2", "write"}, entries[2].OldRule)
+	assert.Equal(t, []string{"bob", "data3", "write"}, entries[2].NewRule)
+
+	assert.Equal(t, ChangeAdd, entries[3].Op)
+	assert.Equal(t, []string{"carol", "data3", "read"}, entries[3].NewRule)
+	assert.Equal(t, "admin", entries[3].Actor)
+	assert.Equal(t, "ticket 42", entries[3].Reason)
+	assert.False(t, entries[3].CreatedAt.IsZero())
+
+	entries, err = a.AuditLog(ctx, AuditQuery{Ptype: "g", Actor: "admin", Limit: 1})
+	assert.Nil(t, err)
+	assert.Len(t, entries, 1)
+	assert.Equal(t, ChangeRemove, entries[0].Op)
+
+	_, err = (&Adapter{}).AuditLog(ctx, AuditQuery{})
+	assert.Equal(t, errAuditLogDisabled, err)
+}
+
+func TestAuditUpdateFiltered(t *testing.T) {
+	a, err := NewAdapterWithOptions(
+		WithTableName("test_casbin_rule_audited"),
+		WithAutoCreateTable(true),
+		WithAuditLog("test_casbin_rule_audit"),
+	)
+	assert.Nil(t, err)
+	initPolicy(t, a)
+	ctx := context.Background()
+	start := latestAuditEntry(t, a)
+
+	// Replacing as many rules as are matched pairs the old and the new rules.
+	_, err = a.UpdateFilteredPoliciesCtx(ctx, "p", "p", [][]string{{"bob", "data3", "write"}}, 0, "bob")
+	assert.Nil(t, err)
+	entries := auditEntriesAfter(t, a, start)
+	assert.Len(t, entries, 1)
+	assert.Equal(t, ChangeUpdate, entries[0].Op)
+	assert.Equal(t, []string{"bob", "data2", "write"}, entries[0].OldRule)
+	assert.Equal(t, []string{"bob", "data3", "write"}, entries[0].NewRule)
+
+	// Otherwise the old rules are recorded as removed and the new rules as added, in one update.
+	start = entries[0].ID
+	_, err = a.UpdateFilteredPoliciesCtx(ctx, "p", "p", [][]string{{"data2_admin", "data3", "read"}}, 0, "data2_admin")
+	assert.Nil(t, err)
+	entries = auditEntriesAfter(t, a, start)
+	assert.Len(t, entries, 3)
+	var oldRules, newRules [][]string
+	for _, entry := range entries {
+		assert.Equal(t, ChangeUpdate, entry.Op)
+		assert.Equal(t, "p", entry.Ptype)
+		assert.True(t, entry.OldRule == nil || entry.NewRule == nil)
+		if entry.OldRule != nil {
+			oldRules = append(oldRules, entry.OldRule)
+		} else {
+			newRules = append(newRules, entry.NewRule)
+		}
+	}
+	assert.True(t, arrayEqualsWithoutOrder([][]string{{"data2_admin", "data2", "read"}, {"data2_admin", "data2", "write"}}, oldRules))
+	assert.Equal(t, [][]string{{"data2_admin", "data3", "read"}}, newRules)
+}
@@ -0,0 +1,467 @@
+package gfadapter
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/casbin/casbin/v2/model"
+	"github.com/casbin/casbin/v2/persist"
+	"github.com/gogf/gf/v2/database/gdb"
+	"github.com/gogf/gf/v2/os/gcache"
+	"github.com/gogf/gf/v2/util/guid"
+)
+
+// DefaultCacheTTL is the time the loaded rules are cached, used when no TTL is given.
+const DefaultCacheTTL = 10 * time.Minute
+
+var (
+	// Check if the persist.*Adapter interfaces are implemented by the cached adapter
+
+	_ persist.Adapter                 = new(CachedAdapter)
+	_ persist.BatchAdapter            = new(CachedAdapter)
+	_ persist.ContextAdapter          = new(CachedAdapter)
+	_ persist.ContextBatchAdapter     = new(CachedAdapter)
+	_ persist.ContextFilteredAdapter  = new(CachedAdapter)
+	_ persist.ContextUpdatableAdapter = new(CachedAdapter)
+	_ persist.FilteredAdapter         = new(CachedAdapter)
+	_ persist.UpdatableAdapter        = new(CachedAdapter)
+	_ persist.TransactionalAdapter    = new(CachedAdapter)
+)
+
+// CachedAdapter caches the rules loaded by an Adapter in a gcache.Cache, keyed by table and filter,
+// so starting many instances sharing a Redis cache scans the table once.
+// Every change made through the CachedAdapter invalidates the cached rules of its table.
+// A change made in Transaction or BeginTransaction invalidates them again once the transaction has ended,
+// as loads in between do not see the change yet. Call Invalidate after committing other transactions joined by changes.
+// Changes made otherwise, e.g. by the Adapter itself, are seen once Invalidate is called or the TTL is over.
+// The rules are read from the master on a cache miss, so no outdated replica is cached.
+// With WithValidityWindows the rules are cached at most until the next rule enters or leaves its validity window.
+type CachedAdapter struct {
+	adapter *Adapter
+	cache   *gcache.Cache
+	ttl     time.Duration
+	hits    atomic.Int64
+	misses  atomic.Int64
+}
+
+// txChangesKey is the context key of the txChanges of a CachedAdapter.Transaction.
+type txChangesKey struct{}
+
+// txChanges holds the key prefixes of the cached rules changed in a CachedAdapter.Transaction.
+type txChanges struct {
+	mu       sync.Mutex
+	prefixes map[string]bool
+}
+
+// add records a change of the cached rules with the given key prefix.
+func (t *txChanges) add(prefix string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.prefixes == nil {
+		t.prefixes = make(map[string]bool)
+	}
+	t.prefixes[prefix] = true
+}
+
+// CacheStats holds the number of loads served from the cache and read from the database.
+type CacheStats struct {
+	Hits   int64
+	Misses int64
+}
+
+// NewCachedAdapter returns a CachedAdapter caching the rules loaded by adapter in cache for ttl.
+// An in-memory cache is used if cache is nil, gcache.NewWithAdapter(gcache.NewAdapterRedis(redis)) shares
+// the cache between instances. DefaultCacheTTL is used if ttl is not positive.
+func NewCachedAdapter(adapter *Adapter, cache *gcache.Cache, ttl time.Duration) *CachedAdapter {
+	if cache == nil {
+		cache = gcache.New()
+	}
+	if ttl <= 0 {
+		ttl = DefaultCacheTTL
+	}
+	return &CachedAdapter{adapter: adapter, cache: cache, ttl: ttl}
+}
+
+// Adapter returns the cached adapter. Changes made through it do not invalidate the cache.
+func (c *CachedAdapter) Adapter() *Adapter {
+	return c.adapter
+}
+
+// Stats returns the number of cache hits and misses since the CachedAdapter was created.
+func (c *CachedAdapter) Stats() CacheStats {
+	return CacheStats{Hits: c.hits.Load(), Misses: c.misses.Load()}
+}
+
+// Invalidate drops the cached rules of the table of ctx, for all instances sharing the cache.
+func (c *CachedAdapter) Invalidate(ctx context.Context) error {
+	prefix, err := c.tablePrefix(ctx)
+	if err != nil {
+		return err
+	}
+	return c.invalidate(ctx, prefix)
+}
+
+// invalidate drops the cached rules with the given key prefix.
+func (c *CachedAdapter) invalidate(ctx context.Context, prefix string) error {
+	// The cached rules are keyed by the generation, so a new generation drops them all at once.
+	return c.cache.Set(ctx, prefix+":generation", guid.S(), 0)
+}
+
+// invalidateAfter invalidates the cached rules if the change returning err succeeded.
+// A change in Transaction is not seen by the other loads before it is committed,
+// so the cached rules are invalidated again once the transaction has ended.
+func (c *CachedAdapter) invalidateAfter(ctx context.Context, err error) error {
+	if err != nil {
+		return err
+	}
+	prefix, err := c.tablePrefix(ctx)
+	if err != nil {
+		return err
+	}
+	if changes, ok := ctx.Value(txChangesKey{}).(*txChanges); ok {
+		changes.add(prefix)
+	}
+	return c.invalidate(ctx, prefix)
+}
+
+// Transaction calls f in a transaction of the database of the adapter, like gdb.DB.Transaction.
+// The rules changed through the CachedAdapter with the context passed to f are invalidated again
+// once the transaction is committed or rolled back, so the rules loaded by others meanwhile are not kept cached.
+// A transaction carried by ctx or joined by the adapter is joined, the rules are then invalidated again
+// when the enclosing Transaction ends, or must be invalidated with Invalidate after the commit.
+func (c *CachedAdapter) Transaction(ctx context.Context, f func(ctx context.Context) error) error {
+	run := func(ctx context.Context, tx gdb.TX) error {
+		return f(ctx)
+	}
+	if c.adapter.dao.TX() != nil || gdb.TXFromCtx(ctx, c.adapter.dao.DB().GetGroup()) != nil {
+		return c.adapter.dao.Transaction(ctx, run)
+	}
+	changes := &txChanges{}
+	err := c.adapter.dao.Transaction(context.WithValue(ctx, txChangesKey{}, changes), run)
+	// The transaction has ended, so the loads from now on read its outcome.
+	changes.mu.Lock()
+	defer changes.mu.Unlock()
+	for prefix := range changes.prefixes {
+		if e := c.invalidate(ctx, prefix); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// tablePrefix returns the prefix of the cache keys of the rules of the table of ctx.
+func (c *CachedAdapter) tablePrefix(ctx context.Context) (string, error) {
+	a, err := c.adapter.forTenant(ctx)
+	if err != nil {
+		return "", err
+	}
+	return c.keyPrefix(ctx, a)
+}
+
+// keyPrefix returns the prefix of the cache keys of the rules read by a, which works on the table of ctx.
+func (c *CachedAdapter) keyPrefix(ctx context.Context, a *Adapter) (string, error) {
+	prefix := fmt.Sprintf("gfadapter:%s:%s", a.dao.Group(), a.dao.Table())
+	if a.tenantMode {
+		tenant, err := a.tenant(ctx)
+		if err != nil {
+			return "", err
+		}
+		prefix += ":" + tenant
+	}
+	return prefix, nil
+}
+
+// generation returns the current generation of the cached rules with the given key prefix.
+func (c *CachedAdapter) generation(ctx context.Context, prefix string) (string, error) {
+	key := prefix + ":generation"
+	value, err := c.cache.Get(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	if value != nil && !value.IsNil() {
+		return value.String(), nil
+	}
+	if _, err = c.cache.SetIfNotExist(ctx, key, guid.S(), 0); err != nil {
+		return "", err
+	}
+	// Another instance may have set the generation first.
+	value, err = c.cache.Get(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	return value.String(), nil
+}
+
+// policyLines returns the cached policy lines of a with the given kind, or reads and caches them.
+func (c *CachedAdapter) policyLines(ctx context.Context, a *Adapter, kind string, read func(ctx context.Context) ([][]string, error)) ([][]string, error) {
+	prefix, err := c.keyPrefix(ctx, a)
+	if err != nil {
+		return nil, err
+	}
+	generation, err := c.generation(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+	key := prefix + ":" + generation + ":" + kind
+	value, err := c.cache.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if value != nil && !value.IsNil() {
+		var lines [][]string
+		if err = json.Unmarshal(value.Bytes(), &lines); err == nil {
+			c.hits.Add(1)
+			return lines, nil
+		}
+	}
+
+	c.misses.Add(1)
+	if readNode(ctx) == ReadNodeAuto {
+		ctx = WithReadNode(ctx, ReadNodeMaster)
+	}
+	now := time.Now()
+	lines, err := read(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// The rules read are only valid until the next rule enters or leaves its validity window.
+	ttl := c.ttl
+	next, err := a.nextValidityChange(ctx, now)
+	if err != nil {
+		return nil, err
+	}
+	if !next.IsZero() {
+		ttl = min(ttl, time.Until(next))
+	}
+	if ttl < time.Millisecond {
+		return lines, nil
+	}
+	data, err := json.Marshal(lines)
+	if err != nil {
+		return nil, err
+	}
+	if err = c.cache.Set(ctx, key, string(data), ttl); err != nil {
+		return nil, err
+	}
+	return lines, nil
+}
+
+// filterKey returns the cache key kind of the rules matching the filter.
+func (c *CachedAdapter) filterKey(a *Adapter, filter Filter) (string, error) {
+	where, args, err := a.buildCond(And(filter.Cond, sectionsCond(filter.Sections)))
+	if err != nil {
+		return "", err
+	}
+	data, err := json.Marshal([]interface{}{
+		filter.Ptype, filter.V0, filter.V1, filter.V2, filter.V3, filter.V4, filter.V5, where, args,
+	})
+	if err != nil {
+		return "", err
+	}
+	h := sha256.Sum256(data)
+	return "filter:" + hex.EncodeToString(h[:]), nil
+}
+
+// addPolicyLines adds the lines missing from the model to it, the model is left unchanged if a line fails.
+func addPolicyLines(a *Adapter, model model.Model, lines [][]string) error {
+	var loaded [][]string
+	if err := a.addPolicyLines(model, lines, &loaded); err != nil {
+		unloadPolicyLines(model, loaded)
+		return err
+	}
+	return nil
+}
+
+// LoadPolicy loads all policy rules from the cache or the storage.
+func (c *CachedAdapter) LoadPolicy(model model.Model) error {
+	return c.LoadPolicyCtx(context.Background(), model)
+}
+
+// LoadPolicyCtx loads all policy rules from the cache or the storage.
+func (c *CachedAdapter) LoadPolicyCtx(ctx context.Context, model model.Model) error {
+	a, err := c.adapter.forTenant(ctx)
+	if err != nil {
+		return err
+	}
+	lines, err := c.policyLines(ctx, a, "all", func(ctx context.Context) ([][]string, error) {
+		return a.readPolicy(ctx, a.policyQuery(ctx))
+	})
+	if err != nil {
+		return err
+	}
+	if err = addPolicyLines(a, model, lines); err != nil {
+		return err
+	}
+	a.state.view.Store(viewFull)
+	return nil
+}
+
+// LoadFilteredPolicy loads the policy rules that match the filter from the cache or the storage.
+func (c *CachedAdapter) LoadFilteredPolicy(model model.Model, filter interface{}) error {
+	return c.LoadFilteredPolicyCtx(context.Background(), model, filter)
+}
+
+// LoadFilteredPolicyCtx loads the policy rules that match the filter from the cache or the storage.
+// The rules are added to the rules already in the model like by Adapter.LoadFilteredPolicyCtx.
+func (c *CachedAdapter) LoadFilteredPolicyCtx(ctx context.Context, model model.Model, filter interface{}) error {
+	a, err := c.adapter.forTenant(ctx)
+	if err != nil {
+		return err
+	}
+	filterValue, err := toFilter(filter)
+	if err != nil {
+		return err
+	}
+	kind, err := c.filterKey(a, filterValue)
+	if err != nil {
+		return err
+	}
+	empty := !hasPolicy(model)
+	lines, err := c.policyLines(ctx, a, kind, func(ctx context.Context) ([][]string, error) {
+		query, err := a.filterQuery(ctx, filterValue)
+		if err != nil {
+			return nil, err
+		}
+		return a.readPolicy(ctx, query)
+	})
+	if err != nil {
+		return err
+	}
+	if err = addPolicyLines(a, model, lines); err != nil {
+		return err
+	}
+	a.markFiltered(empty)
+	return nil
+}
+
+// IsFiltered returns true if the loaded policy has been filtered.
+func (c *CachedAdapter) IsFiltered() bool {
+	return c.adapter.IsFiltered()
+}
+
+// IsFilteredCtx returns true if the loaded policy has been filtered.
+func (c *CachedAdapter) IsFilteredCtx(ctx context.Context) bool {
+	return c.adapter.IsFilteredCtx(ctx)
+}
+
+// SavePolicy saves all policy rules to the storage and invalidates the cached rules.
+func (c *CachedAdapter) SavePolicy(model model.Model) error {
+	return c.SavePolicyCtx(context.Background(), model)
+}
+
+// SavePolicyCtx saves all policy rules to the storage and invalidates the cached rules.
+func (c *CachedAdapter) SavePolicyCtx(ctx context.Context, model model.Model) error {
+	return c.invalidateAfter(ctx, c.adapter.SavePolicyCtx(ctx, model))
+}
+
+// AddPolicy adds a policy rule to the storage and invalidates the cached rules.
+func (c *CachedAdapter) AddPolicy(sec string, ptype string, rule []string) error {
+	return c.AddPolicyCtx(context.Background(), sec, ptype, rule)
+}
+
+// AddPolicyCtx adds a policy rule to the storage and invalidates the cached rules.
+func (c *CachedAdapter) AddPolicyCtx(ctx context.Context, sec string, ptype string, rule []string) error {
+	return c.invalidateAfter(ctx, c.adapter.AddPolicyCtx(ctx, sec, ptype, rule))
+}
+
+// AddPolicies adds policy rules to the storage and invalidates the cached rules.
+func (c *CachedAdapter) AddPolicies(sec string, ptype string, rules [][]string) error {
+	return c.AddPoliciesCtx(context.Background(), sec, ptype, rules)
+}
+
+// AddPoliciesCtx adds policy rules to the storage and invalidates the cached rules.
+func (c *CachedAdapter) AddPoliciesCtx(ctx context.Context, sec string, ptype string, rules [][]string) error {
+	return c.invalidateAfter(ctx, c.adapter.AddPoliciesCtx(ctx, sec, ptype, rules))
+}
+
+// RemovePolicy removes a policy rule from the storage and invalidates the cached rules.
+func (c *CachedAdapter) RemovePolicy(sec string, ptype string, rule []string) error {
+	return c.RemovePolicyCtx(context.Background(), sec, ptype, rule)
+}
+
+// RemovePolicyCtx removes a policy rule from the storage and invalidates the cached rules.
+func (c *CachedAdapter) RemovePolicyCtx(ctx context.Context, sec string, ptype string, rule []string) error {
+	return c.invalidateAfter(ctx, c.adapter.RemovePolicyCtx(ctx, sec, ptype, rule))
+}
+
+// RemovePolicies removes policy rules from the storage and invalidates the cached rules.
+func (c *CachedAdapter) RemovePolicies(sec string, ptype string, rules [][]string) error {
+	return c.RemovePoliciesCtx(context.Background(), sec, ptype, rules)
+}
+
+// RemovePoliciesCtx removes policy rules from the storage and invalidates the cached rules.
+func (c *CachedAdapter) RemovePoliciesCtx(ctx context.Context, sec string, ptype string, rules [][]string) error {
+	return c.invalidateAfter(ctx, c.adapter.RemovePoliciesCtx(ctx, sec, ptype, rules))
+}
+
+// RemoveFilteredPolicy removes the policy rules that match the filter from the storage and invalidates the cached rules.
+func (c *CachedAdapter) RemoveFilteredPolicy(sec string, ptype string, fieldIndex int, fieldValues ...string) error {
+	return c.RemoveFilteredPolicyCtx(context.Background(), sec, ptype, fieldIndex, fieldValues...)
+}
+
+// RemoveFilteredPolicyCtx removes the policy rules that match the filter from the storage and invalidates the cached rules.
+func (c *CachedAdapter) RemoveFilteredPolicyCtx(ctx context.Context, sec string, ptype string, fieldIndex int, fieldValues ...string) error {
+	return c.invalidateAfter(ctx, c.adapter.RemoveFilteredPolicyCtx(ctx, sec, ptype, fieldIndex, fieldValues...))
+}
+
+// UpdatePolicy updates a policy rule in the storage and invalidates the cached rules.
+func (c *CachedAdapter) UpdatePolicy(sec string, ptype string, oldRule, newRule []string) error {
+	return c.UpdatePolicyCtx(context.Background(), sec, ptype, oldRule, newRule)
+}
+
+// UpdatePolicyCtx updates a policy rule in the storage and invalidates the cached rules.
+func (c *CachedAdapter) UpdatePolicyCtx(ctx context.Context, sec string, ptype string, oldRule, newRule []string) error {
+	return c.invalidateAfter(ctx, c.adapter.UpdatePolicyCtx(ctx, sec, ptype, oldRule, newRule))
+}
+
+// UpdatePolicies updates policy rules in the storage and invalidates the cached rules.
+func (c *CachedAdapter) UpdatePolicies(sec string, ptype string, oldRules, newRules [][]string) error {
+	return c.UpdatePoliciesCtx(context.Background(), sec, ptype, oldRules, newRules)
+}
+
+// UpdatePoliciesCtx updates policy rules in the storage and invalidates the cached rules.
+func (c *CachedAdapter) UpdatePoliciesCtx(ctx context.Context, sec string, ptype string, oldRules, newRules [][]string) error {
+	return c.invalidateAfter(ctx, c.adapter.UpdatePoliciesCtx(ctx, sec, ptype, oldRules, newRules))
+}
+
+// UpdateFilteredPolicies replaces the policy rules that match the filter and invalidates the cached rules.
+func (c *CachedAdapter) UpdateFilteredPolicies(sec string, ptype string, newRules [][]string, fieldIndex int, fieldValues ...string) ([][]string, error) {
+	return c.UpdateFilteredPoliciesCtx(context.Background(), sec, ptype, newRules, fieldIndex, fieldValues...)
+}
+
+// UpdateFilteredPoliciesCtx replaces the policy rules that match the filter and invalidates the cached rules.
+func (c *CachedAdapter) UpdateFilteredPoliciesCtx(ctx context.Context, sec string, ptype string, newRules [][]string, fieldIndex int, fieldValues ...string) ([][]string, error) {
+	oldRules, err := c.adapter.UpdateFilteredPoliciesCtx(ctx, sec, ptype, newRules, fieldIndex, fieldValues...)
+	if err = c.invalidateAfter(ctx, err); err != nil {
+		return nil, err
+	}
+	return oldRules, nil
+}
+
+// BeginTransaction starts a transaction on the adapter, the cached rules of the table of ctx are invalidated
+// when it is committed.
+func (c *CachedAdapter) BeginTransaction(ctx context.Context) (persist.TransactionContext, error) {
+	t, err := c.adapter.BeginTransaction(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &cachedTransaction{TransactionContext: t, cache: c, ctx: ctx}, nil
+}
+
+// cachedTransaction invalidates the cached rules when the transaction is committed.
+type cachedTransaction struct {
+	persist.TransactionContext
+	cache *CachedAdapter
+	ctx   context.Context
+}
+
+// Commit commits the transaction and invalidates the cached rules.
+func (t *cachedTransaction) Commit() error {
+	return t.cache.invalidateAfter(t.ctx, t.TransactionContext.Commit())
+}
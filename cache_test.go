@@ -0,0 +1,171 @@
+package gfadapter
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/casbin/casbin/v2"
+	"github.com/casbin/casbin/v2/model"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/yclw/gf-casbin-adapter/dao"
+)
+
+func TestCacheKeys(t *testing.T) {
+	a := &Adapter{
+		dao:          dao.NewCasbinRuleDaoWithName("casbin_rule"),
+		valueColumns: []string{"v0", "v1", "v2", "v3", "v4", "v5"},
+		state:        &adapterState{},
+	}
+	c := NewCachedAdapter(a, nil, 0)
+	ctx := context.Background()
+
+	prefix, err := c.keyPrefix(ctx, a)
+	assert.Nil(t, err)
+	assert.Equal(t, "gfadapter:default:casbin_rule", prefix)
+	generation, err := c.generation(ctx, prefix)
+	assert.Nil(t, err)
+	again, err := c.generation(ctx, prefix)
+	assert.Nil(t, err)
+	assert.Equal(t, generation, again)
+	assert.Nil(t, c.Invalidate(ctx))
+	again, err = c.generation(ctx, prefix)
+	assert.Nil(t, err)
+	assert.NotEqual(t, generation, again)
+
+	key, err := c.filterKey(a, Filter{V0: []string{"alice"}})
+	assert.Nil(t, err)
+	same, err := c.filterKey(a, Filter{Cond: Eq("v0", "alice")})
+	assert.Nil(t, err)
+	other, err := c.filterKey(a, Filter{V0: []string{"bob"}})
+	assert.Nil(t, err)
+	assert.NotEqual(t, key, same)
+	assert.NotEqual(t, key, other)
+	again, err = c.filterKey(a, Filter{V0: []string{"alice"}})
+	assert.Nil(t, err)
+	assert.Equal(t, key, again)
+
+	// The rules of a tenant are cached separately.
+	a.tenantMode = true
+	prefix, err = c.keyPrefix(WithTenant(ctx, "acme"), a)
+	assert.Nil(t, err)
+	assert.Equal(t, "gfadapter:default:casbin_rule:acme", prefix)
+	_, err = c.keyPrefix(ctx, a)
+	assert.Equal(t, errNoTenant, err)
+}
+
+func TestCachedAdapter(t *testing.T) {
+	a := initAdapterWithName(t, "test_casbin_rule_cache")
+	c := NewCachedAdapter(a, nil, 0)
+
+	e, err := casbin.NewEnforcer("examples/rbac_model.conf", c)
+	assert.Nil(t, err)
+	assert.Nil(t, e.LoadPolicy())
+	assert.Equal(t, CacheStats{Hits: 1, Misses: 1}, c.Stats())
+
+	// Changes made through the cached adapter are loaded at once.
+	_, err = e.AddPolicy("carol", "data3", "read")
+	assert.Nil(t, err)
+	assert.Nil(t, e.LoadPolicy())
+	assert.Equal(t, CacheStats{Hits: 1, Misses: 2}, c.Stats())
+	testGetPolicy(t, e, [][]string{{"alice", "data1", "read"}, {"bob", "data2", "write"}, {"data2_admin", "data2", "read"}, {"data2_admin", "data2", "write"}, {"carol", "data3", "read"}})
+
+	// Changes made by the adapter itself are loaded after Invalidate.
+	assert.Nil(t, a.RemovePolicy("p", "p", []string{"carol", "data3", "read"}))
+	assert.Nil(t, e.LoadPolicy())
+	ok, err := e.HasPolicy("carol", "data3", "read")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Nil(t, c.Invalidate(context.Background()))
+	assert.Nil(t, e.LoadPolicy())
+	testGetPolicy(t, e, [][]string{{"alice", "data1", "read"}, {"bob", "data2", "write"}, {"data2_admin", "data2", "read"}, {"data2_admin", "data2", "write"}})
+
+	// Filtered rules are cached by filter.
+	e.ClearPolicy()
+	assert.Nil(t, e.LoadFilteredPolicy(Filter{V0: []string{"alice"}}))
+	e.ClearPolicy()
+	assert.Nil(t, e.LoadFilteredPolicy(Filter{V0: []string{"alice"}}))
+	testGetPolicy(t, e, [][]string{{"alice", "data1", "read"}})
+	assert.True(t, e.IsFiltered())
+	assert.Equal(t, CacheStats{Hits: 3, Misses: 4}, c.Stats())
+}
+
+func TestCachedValidity(t *testing.T) {
+	a, err := NewAdapterWithOptions(
+		WithTableName("test_casbin_rule_cache_validity"),
+		WithAutoCreateTable(true),
+		WithValidityWindows(true),
+	)
+	assert.Nil(t, err)
+	ctx := context.Background()
+	_, err = a.dao.Ctx(ctx).WhereGT(a.dao.Columns().Id, 0).Delete()
+	assert.Nil(t, err)
+	initPolicy(t, a)
+	c := NewCachedAdapter(a, nil, 0)
+	now := time.Now()
+	assert.Nil(t, a.AddPolicyWithExpiryCtx(ctx, "p", "p", []string{"carol", "data3", "read"}, time.Time{}, now.Add(2*time.Second)))
+	assert.Nil(t, a.AddPolicyWithExpiryCtx(ctx, "p", "p", []string{"dave", "data3", "read"}, now.Add(time.Hour), time.Time{}))
+
+	e, err := casbin.NewEnforcer("examples/rbac_model.conf", c)
+	assert.Nil(t, err)
+	assert.Nil(t, e.LoadPolicy())
+	assert.Equal(t, CacheStats{Hits: 1, Misses: 1}, c.Stats())
+	ok, err := e.HasPolicy("carol", "data3", "read")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+
+	// The cached rules expire with the window of carol rather than with the TTL.
+	// The window may end up to half a second later, as the time is stored in whole seconds.
+	time.Sleep(time.Until(now.Add(3 * time.Second)))
+	assert.Nil(t, e.LoadPolicy())
+	assert.Equal(t, CacheStats{Hits: 1, Misses: 2}, c.Stats())
+	testGetPolicy(t, e, [][]string{{"alice", "data1", "read"}, {"bob", "data2", "write"}, {"data2_admin", "data2", "read"}, {"data2_admin", "data2", "write"}})
+}
+
+func TestCachedTransaction(t *testing.T) {
+	a := initAdapterWithName(t, "test_casbin_rule_cache_tx")
+	c := NewCachedAdapter(a, nil, 0)
+	ctx := context.Background()
+	carol := []string{"carol", "data3", "read"}
+	m, err := model.NewModelFromFile("examples/rbac_model.conf")
+	assert.Nil(t, err)
+
+	err = c.Transaction(ctx, func(ctx context.Context) error {
+		if err := c.AddPolicyCtx(ctx, "p", "p", carol); err != nil {
+			return err
+		}
+		// A concurrent load does not see the change before the commit and caches the rules without it.
+		done := make(chan error)
+		go func() {
+			done <- c.LoadPolicyCtx(context.Background(), m)
+		}()
+		return <-done
+	})
+	assert.Nil(t, err)
+	assert.False(t, modelHasPolicy(m, carol))
+
+	// The rules cached before the commit are invalidated once it is done.
+	m.ClearPolicy()
+	assert.Nil(t, c.LoadPolicyCtx(ctx, m))
+	assert.True(t, modelHasPolicy(m, carol))
+
+	// So are the rules cached before a rollback.
+	err = c.Transaction(ctx, func(ctx context.Context) error {
+		if err := c.RemovePolicyCtx(ctx, "p", "p", carol); err != nil {
+			return err
+		}
+		m.ClearPolicy()
+		if err := c.LoadPolicyCtx(ctx, m); err != nil {
+			return err
+		}
+		return errors.New("rollback")
+	})
+	assert.NotNil(t, err)
+	assert.False(t, modelHasPolicy(m, carol))
+	m.ClearPolicy()
+	assert.Nil(t, c.LoadPolicyCtx(ctx, m))
+	assert.True(t, modelHasPolicy(m, carol))
+	assert.Nil(t, a.RemovePolicyCtx(ctx, "p", "p", carol))
+}
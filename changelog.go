@@ -0,0 +1,312 @@
+package gfadapter
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/casbin/casbin/v2/model"
+	"github.com/gogf/gf/v2/database/gdb"
+	"github.com/gogf/gf/v2/frame/g"
+)
+
+// DefaultChangeLogTableName is the name of the change log table used when WithChangeLog is given an empty name.
+const DefaultChangeLogTableName = "casbin_rule_change"
+
+// errChangeLogDisabled is returned by the change log functions of an adapter created without WithChangeLog.
+var errChangeLogDisabled = errors.New("the change log is not enabled, create the adapter with WithChangeLog")
+
+// Operations recorded in the change log.
+const (
+	ChangeAdd    = "add"    // ChangeAdd records added rules.
+	ChangeRemove = "remove" // ChangeRemove records removed rules.
+	ChangeUpdate = "update" // ChangeUpdate records rules replaced by new rules.
+	ChangeSave   = "save"   // ChangeSave records that all rules have been replaced by SavePolicy.
+	ChangeReload = "reload" // ChangeReload asks the other instances to reload all rules.
+)
+
+// change is a change of the rules recorded in the change log.
+type change struct {
+	op       string
+	sec      string
+	ptype    string
+	rules    [][]string
+	newRules [][]string
+	// diff is set by SavePolicy in SaveModeDiff, with the policy lines it removed and added.
+	// They are only recorded in the audit log.
+	diff         bool
+	removedLines [][]string
+	addedLines   [][]string
+	// auditOnly keeps the change out of the change log, e.g. for a rule whose validity window has not started.
+	auditOnly bool
+}
+
+// changeLogTableDef returns the definition of the change log table.
+func changeLogTableDef(tableName string, columnLength int) tableDef {
+	return tableDef{
+		name: tableName,
+		columns: []columnDef{
+			{name: "id", kind: kindID},
+			{name: "op", kind: kindString, size: 16},
+			{name: "sec", kind: kindString, size: 16},
+			{name: "ptype", kind: kindString, size: columnLength},
+			{name: "rules", kind: kindText},
+			{name: "new_rules", kind: kindText},
+			{name: "source", kind: kindString, size: 64},
+			{name: "created_at", kind: kindTime},
+		},
+		indexes: []indexDef{
+			{name: "idx_created_at", columns: []string{"created_at"}},
+		},
+		comment: "Casbin rule change log",
+	}
+}
+
+// auxTableDefs returns the definitions of the enabled tables besides the rule table.
+func (a *Adapter) auxTableDefs() []tableDef {
+	var defs []tableDef
+	if a.changeLogTable != "" {
+		defs = append(defs, changeLogTableDef(a.changeLogTable, a.columnLength))
+	}
+	if a.auditTable != "" {
+		defs = append(defs, auditTableDef(a.auditTable, a.columnLength))
+	}
+	if a.snapshotTable != "" {
+		defs = append(defs,
+			snapshotTableDef(a.snapshotTable),
+			snapshotRuleTableDef(a.snapshotRuleTable(), len(a.valueColumns), a.columnLength),
+		)
+	}
+	if a.tenantLogs {
+		// The rules of a snapshot belong to the tenant of the snapshot.
+		for i, def := range defs {
+			if def.name != a.snapshotRuleTable() {
+				defs[i] = withTenantColumn(def)
+			}
+		}
+	}
+	return defs
+}
+
+// recordChange writes the change to the change log and the audit log within tx, if they are enabled.
+// A nil tx writes the change outside of a transaction.
+func (a *Adapter) recordChange(ctx context.Context, tx gdb.TX, c change) error {
+	a.state.lastChange.Store(time.Now().UnixNano())
+	if err := a.recordAudit(ctx, tx, c); err != nil {
+		return err
+	}
+	if a.changeLogTable == "" || c.auditOnly {
+		return nil
+	}
+	data := g.Map{
+		"op":         c.op,
+		"sec":        c.sec,
+		"ptype":      c.ptype,
+		"source":     a.instanceID,
+		"created_at": time.Now(),
+	}
+	if c.rules != nil {
+		rules, err := json.Marshal(c.rules)
+		if err != nil {
+			return err
+		}
+		data["rules"] = string(rules)
+	}
+	if c.newRules != nil {
+		newRules, err := json.Marshal(c.newRules)
+		if err != nil {
+			return err
+		}
+		data["new_rules"] = string(newRules)
+	}
+	m := a.logModel(ctx, a.changeLogTable)
+	if tx != nil {
+		m = m.TX(tx)
+	}
+	_, err := m.Data(data).Insert()
+	return err
+}
+
+// LatestChange returns the sequence number of the latest change in the change log, or 0 if it is empty.
+// Rules loaded by LoadPolicy after calling it can be kept up to date with LoadPolicySince.
+func (a *Adapter) LatestChange(ctx context.Context) (int64, error) {
+	if a.changeLogTable == "" {
+		return 0, errChangeLogDisabled
+	}
+	value, err := a.model(ctx, a.changeLogTable).Max("id")
+	if err != nil {
+		return 0, err
+	}
+	return int64(value), nil
+}
+
+// LoadPolicySince applies the changes recorded after the sequence number since to the model
+// and returns the sequence number of the latest applied change.
+// The model must hold the rules as of since, as left by the previous call or by LoadPolicy after LatestChange.
+// All rules are loaded again instead if since is 0, if the changes after since have been compacted,
+// or if all rules have been replaced, e.g. by SavePolicy.
+// A sequence number missing before the latest change may belong to a transaction that has not committed yet,
+// so the returned number stops before it unless the change following it was recorded more than DefaultGapTimeout ago.
+// The changes after it are applied again by the next call, which leaves the rules unchanged.
+// With WithTenantScope or WithTenantTables only the changes of the tenant are applied, the sequence numbers are shared by all tenants.
+// The role links have to be built again afterwards, e.g. by Enforcer.BuildRoleLinks.
+func (a *Adapter) LoadPolicySince(ctx context.Context, model model.Model, since int64) (int64, error) {
+	if a.changeLogTable == "" {
+		return 0, errChangeLogDisabled
+	}
+	if since <= 0 {
+		return a.reloadPolicy(ctx, model)
+	}
+
+	first, err := a.model(ctx, a.changeLogTable).Min("id")
+	if err != nil {
+		return 0, err
+	}
+	// The changes following since are missing if they have been compacted.
+	if first == 0 || int64(first) > since+1 {
+		return a.reloadPolicy(ctx, model)
+	}
+
+	// The sequence numbers are shared by all tenants, so the changes of the other tenants are read but not applied.
+	fields := []string{"id", "op", "sec", "ptype", "rules", "new_rules", "created_at"}
+	var tenant string
+	if a.tenantLogs {
+		if tenant, err = a.tenant(ctx); err != nil {
+			return 0, err
+		}
+		fields = append(fields, tenantColumn.name)
+	}
+
+	// The changes are applied in order, those after a missing sequence number are applied again by the next call.
+	changes := newChangeCursor(since, DefaultGapTimeout)
+	last := since
+	for {
+		result, err := a.model(ctx, a.changeLogTable).
+			Fields(fields).
+			WhereGT("id", last).
+			Order("id").
+			Limit(pollLimit).
+			All()
+		if err != nil {
+			return 0, err
+		}
+		ids := make([]int64, 0, len(result))
+		for _, record := range result {
+			id := record["id"].Int64()
+			// A number missing before a change recorded long ago belongs to a rolled back transaction.
+			for missing := last + 1; missing < id; missing++ {
+				changes.miss(missing, record["created_at"].Time())
+			}
+			if a.tenantLogs && record[tenantColumn.name].String() != tenant {
+				ids = append(ids, id)
+				last = id
+				continue
+			}
+			c, err := changeFromRecord(record)
+			if err != nil {
+				return 0, err
+			}
+			if c.op == ChangeSave || c.op == ChangeReload {
+				return a.reloadPolicy(ctx, model)
+			}
+			if err = applyChange(model, c); err != nil {
+				return 0, fmt.Errorf("change %d: %w", id, err)
+			}
+			ids = append(ids, id)
+			last = id
+		}
+		changes.advance(ids, time.Now())
+		if len(result) < pollLimit {
+			return changes.cursor, nil
+		}
+	}
+}
+
+// reloadPolicy replaces the rules of the model by all rules and returns the latest change they include.
+func (a *Adapter) reloadPolicy(ctx context.Context, model model.Model) (int64, error) {
+	// The changes recorded during the load are applied again by the next call, which leaves the rules unchanged.
+	// The rules are read from the master like the change log, so they include the latest change.
+	if readNode(ctx) == ReadNodeAuto {
+		ctx = WithReadNode(ctx, ReadNodeMaster)
+	}
+	latest, err := a.LatestChange(ctx)
+	if err != nil {
+		return 0, err
+	}
+	model.ClearPolicy()
+	if err = a.LoadPolicyCtx(ctx, model); err != nil {
+		return 0, err
+	}
+	return latest, nil
+}
+
+// CompactChangeLog deletes the changes recorded before the given time and returns the number of deleted changes.
+// The latest change is always kept, so LoadPolicySince can tell compacted changes from an empty change log.
+func (a *Adapter) CompactChangeLog(ctx context.Context, before time.Time) (int64, error) {
+	latest, err := a.LatestChange(ctx)
+	if err != nil || latest == 0 {
+		return 0, err
+	}
+	result, err := a.model(ctx, a.changeLogTable).
+		WhereLT("created_at", before).
+		WhereLT("id", latest).
+		Delete()
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// changeFromRecord decodes a change read from the change log.
+func changeFromRecord(record gdb.Record) (change, error) {
+	c := change{
+		op:    record["op"].String(),
+		sec:   record["sec"].String(),
+		ptype: record["ptype"].String(),
+	}
+	var err error
+	if c.rules, err = decodeRules(record["rules"].String()); err != nil {
+		return c, err
+	}
+	if c.newRules, err = decodeRules(record["new_rules"].String()); err != nil {
+		return c, err
+	}
+	return c, nil
+}
+
+// decodeRules decodes rules encoded by recordChange.
+// Trailing empty values are dropped like when the rules are loaded from the rule table.
+func decodeRules(s string) ([][]string, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var rules [][]string
+	if err := json.Unmarshal([]byte(s), &rules); err != nil {
+		return nil, err
+	}
+	for i, rule := range rules {
+		rules[i] = rule[:findLastNonEmptyIndex(rule)]
+	}
+	return rules, nil
+}
+
+// applyChange applies the change to the model.
+// Rules already added or removed are skipped, so a change can be applied more than once.
+func applyChange(model model.Model, c change) error {
+	var err error
+	switch c.op {
+	case ChangeAdd:
+		_, err = model.AddPoliciesWithAffected(c.sec, c.ptype, c.rules)
+	case ChangeRemove:
+		_, err = model.RemovePoliciesWithAffected(c.sec, c.ptype, c.rules)
+	case ChangeUpdate:
+		if _, err = model.RemovePoliciesWithAffected(c.sec, c.ptype, c.rules); err == nil {
+			_, err = model.AddPoliciesWithAffected(c.sec, c.ptype, c.newRules)
+		}
+	default:
+		err = fmt.Errorf("unknown change operation %q", c.op)
+	}
+	return err
+}
@@ -0,0 +1,107 @@
+package gfadapter
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/casbin/casbin/v2"
+	"github.com/casbin/casbin/v2/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApplyChange(t *testing.T) {
+	m, err := model.NewModelFromFile("examples/rbac_model.conf")
+	assert.Nil(t, err)
+
+	rules, err := decodeRules(`[["alice","data1","read"],["bob","data2","write",""]]`)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]string{{"alice", "data1", "read"}, {"bob", "data2", "write"}}, rules)
+
+	add := change{op: ChangeAdd, sec: "p", ptype: "p", rules: rules}
+	assert.Nil(t, applyChange(m, add))
+	// Applying a change twice leaves the rules unchanged.
+	assert.Nil(t, applyChange(m, add))
+	policy, _ := m.GetPolicy("p", "p")
+	assert.Equal(t, rules, policy)
+
+	assert.Nil(t, applyChange(m, change{
+		op:       ChangeUpdate,
+		sec:      "p",
+		ptype:    "p",
+		rules:    [][]string{{"alice", "data1", "read"}},
+		newRules: [][]string{{"alice", "data1", "write"}},
+	}))
+	assert.Nil(t, applyChange(m, change{op: ChangeRemove, sec: "p", ptype: "p", rules: [][]string{{"bob", "data2", "write"}}}))
+	policy, _ = m.GetPolicy("p", "p")
+	assert.Equal(t, [][]string{{"alice", "data1", "write"}}, policy)
+
+	assert.NotNil(t, applyChange(m, change{op: "unknown"}))
+}
+
+func TestLoadPolicySince(t *testing.T) {
+	ctx := context.Background()
+	a, err := NewAdapterWithOptions(
+		WithTableName("test_casbin_rule_since"),
+		WithAutoCreateTable(true),
+		WithChangeLog("test_casbin_rule_since_change"),
+	)
+	assert.Nil(t, err)
+	initPolicy(t, a)
+
+	m, err := model.NewModelFromFile("examples/rbac_model.conf")
+	assert.Nil(t, err)
+	since, err := a.LoadPolicySince(ctx, m, 0)
+	assert.Nil(t, err)
+	assert.True(t, since > 0)
+
+	assert.Nil(t, a.AddPolicy("p", "p", []string{"carol", "data3", "read"}))
+	assert.Nil(t, a.RemovePolicy("p", "p", []string{"alice", "data1", "read"}))
+	assert.Nil(t, a.UpdatePolicy("p", "p", []string{"bob", "data2", "write"}, []string{"bob", "data3", "write"}))
+	assert.Nil(t, a.RemoveFilteredPolicy("g", "g", 0, "alice"))
+
+	latest, err := a.LoadPolicySince(ctx, m, since)
+	assert.Nil(t, err)
+	assert.True(t, latest > since)
+
+	e, err := casbin.NewEnforcer("examples/rbac_model.conf", a)
+	assert.Nil(t, err)
+	loaded, _ := e.GetPolicy()
+	policy, _ := m.GetPolicy("p", "p")
+	assert.True(t, arrayEqualsWithoutOrder(loaded, policy))
+	grouping, _ := m.GetPolicy("g", "g")
+	assert.Empty(t, grouping)
+
+	// The changes after since are gone, so all rules are loaded again.
+	_, err = a.CompactChangeLog(ctx, time.Now().Add(time.Minute))
+	assert.Nil(t, err)
+	m.ClearPolicy()
+	_, err = a.LoadPolicySince(ctx, m, since)
+	assert.Nil(t, err)
+	policy, _ = m.GetPolicy("p", "p")
+	assert.True(t, arrayEqualsWithoutOrder(loaded, policy))
+	since, err = a.LatestChange(ctx)
+	assert.Nil(t, err)
+
+	// A change committed after a later one is not skipped.
+	tx, err := a.dao.DB().Begin(ctx)
+	assert.Nil(t, err)
+	assert.Nil(t, a.WithTx(tx).AddPolicy("p", "p", []string{"dave", "data4", "read"}))
+	assert.Nil(t, a.AddPolicy("p", "p", []string{"erin", "data5", "read"}))
+	cursor, err := a.LoadPolicySince(ctx, m, since)
+	assert.Nil(t, err)
+	assert.Equal(t, since, cursor)
+	assert.True(t, modelHasPolicy(m, []string{"erin", "data5", "read"}))
+	assert.Nil(t, tx.Commit())
+	cursor, err = a.LoadPolicySince(ctx, m, cursor)
+	assert.Nil(t, err)
+	assert.Equal(t, since+2, cursor)
+	assert.True(t, modelHasPolicy(m, []string{"dave", "data4", "read"}))
+	assert.True(t, modelHasPolicy(m, []string{"erin", "data5", "read"}))
+}
+
+// modelHasPolicy reports whether the model holds the given p rule.
+func modelHasPolicy(m model.Model, rule []string) bool {
+	ok, _ := m.HasPolicy("p", "p", rule)
+	return ok
+}
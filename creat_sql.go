@@ -1,10 +1,317 @@
 package gfadapter
 
 import (
+	"database/sql"
 	"fmt"
 	"strings"
 )
 
+// columnKind is the abstract type of a column, it is mapped to a concrete type per database type.
+type columnKind int
+
+const (
+	// kindID is an auto increment primary key.
+	kindID columnKind = iota
+	// kindString is a non null string defaulting to ''.
+	kindString
+	// kindInt is a non null 64-bit integer defaulting to 0.
+	kindInt
+	// kindTime is a nullable timestamp.
+	kindTime
+	// kindText is a nullable string without length limit.
+	kindText
+)
+
+// columnDef describes a table column.
+type columnDef struct {
+	name string
+	kind columnKind
+	size int
+}
+
+// indexDef describes a table index.
+type indexDef struct {
+	name    string
+	columns []string
+	unique  bool
+}
+
+// tableDef describes a table and its indexes.
+type tableDef struct {
+	name    string
+	columns []columnDef
+	indexes []indexDef
+	comment string
+}
+
+// dialect holds the column types of a database type.
+type dialect struct {
+	id        string // id is the auto increment primary key type.
+	varchar   string // varchar is the string type, %d is replaced by the column size.
+	bigint    string // bigint is the 64-bit integer type.
+	timestamp string // timestamp is the nullable timestamp type.
+	text      string // text is the nullable string type without length limit.
+	notNull   bool   // notNull marks string and integer columns NOT NULL.
+}
+
+// dialects maps the database types supported by GoFrame to their column types.
+var dialects = map[string]dialect{
+	"mysql":      {id: "BIGINT AUTO_INCREMENT PRIMARY KEY", varchar: "VARCHAR(%d)", bigint: "BIGINT", timestamp: "DATETIME NULL", text: "LONGTEXT NULL", notNull: true},
+	"mariadb":    {id: "BIGINT AUTO_INCREMENT PRIMARY KEY", varchar: "VARCHAR(%d)", bigint: "BIGINT", timestamp: "DATETIME NULL", text: "LONGTEXT NULL", notNull: true},
+	"tidb":       {id: "BIGINT AUTO_INCREMENT PRIMARY KEY", varchar: "VARCHAR(%d)", bigint: "BIGINT", timestamp: "DATETIME NULL", text: "LONGTEXT NULL", notNull: true},
+	"pgsql":      {id: "BIGSERIAL PRIMARY KEY", varchar: "VARCHAR(%d)", bigint: "BIGINT", timestamp: "TIMESTAMP NULL", text: "TEXT NULL", notNull: true},
+	"sqlite":     {id: "INTEGER PRIMARY KEY AUTOINCREMENT", varchar: "TEXT", bigint: "INTEGER", timestamp: "DATETIME NULL", text: "TEXT NULL", notNull: true},
+	"sqlite3":    {id: "INTEGER PRIMARY KEY AUTOINCREMENT", varchar: "TEXT", bigint: "INTEGER", timestamp: "DATETIME NULL", text: "TEXT NULL", notNull: true},
+	"sqlserver":  {id: "BIGINT IDENTITY(1,1) PRIMARY KEY", varchar: "NVARCHAR(%d)", bigint: "BIGINT", timestamp: "DATETIME2 NULL", text: "NVARCHAR(MAX) NULL", notNull: true},
+	"mssql":      {id: "BIGINT IDENTITY(1,1) PRIMARY KEY", varchar: "NVARCHAR(%d)", bigint: "BIGINT", timestamp: "DATETIME2 NULL", text: "NVARCHAR(MAX) NULL", notNull: true},
+	"oracle":     {id: "NUMBER GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY", varchar: "VARCHAR2(%d)", bigint: "NUMBER(19)", timestamp: "TIMESTAMP NULL", text: "CLOB NULL", notNull: true},
+	"clickhouse": {id: "UInt64", varchar: "String", bigint: "Int64", timestamp: "Nullable(DateTime)", text: "Nullable(String)"},
+	"dm":         {id: "BIGINT IDENTITY(1,1) PRIMARY KEY", varchar: "VARCHAR(%d)", bigint: "BIGINT", timestamp: "TIMESTAMP NULL", text: "CLOB NULL", notNull: true},
+}
+
+// ruleTableDef returns the definition of the casbin rule table with the given value columns and column length.
+func ruleTableDef(tableName string, valueColumns int, columnLength int) tableDef {
+	t := tableDef{
+		name:    tableName,
+		comment: "Casbin rule table",
+	}
+	names := []string{"ptype"}
+	for i := 0; i < valueColumns; i++ {
+		names = append(names, fmt.Sprintf("v%d", i))
+	}
+	t.columns = append(t.columns, columnDef{name: "id", kind: kindID})
+	for _, name := range names {
+		t.columns = append(t.columns, columnDef{name: name, kind: kindString, size: columnLength})
+		t.indexes = append(t.indexes, indexDef{name: "idx_" + name, columns: []string{name}})
+	}
+	// A composite unique key on ptype and all values exceeds the index length limits of MySQL,
+	// so uniqueness is enforced on a hash of the rule instead.
+	t.columns = append(t.columns, ruleHashColumn)
+	t.indexes = append(t.indexes, ruleHashIndex)
+	return t
+}
+
+// ruleHashColumn stores the hex SHA-256 hash of a rule computed by ruleHash.
+var ruleHashColumn = columnDef{name: "rule_hash", kind: kindString, size: 64}
+
+// ruleHashIndex makes rules unique.
+var ruleHashIndex = indexDef{name: "uk_rule_hash", columns: []string{"rule_hash"}, unique: true}
+
+// baseType returns the column type of the given database type without default value and nullability.
+func baseType(dbType string, c columnDef) string {
+	d, ok := dialects[dbType]
+	if !ok {
+		return ""
+	}
+	switch c.kind {
+	case kindID:
+		return d.id
+	case kindString:
+		if strings.Contains(d.varchar, "%d") {
+			return fmt.Sprintf(d.varchar, c.size)
+		}
+		return d.varchar
+	case kindInt:
+		return d.bigint
+	case kindTime:
+		return d.timestamp
+	case kindText:
+		return d.text
+	}
+	return ""
+}
+
+// columnType returns the column type of the given database type.
+func columnType(dbType string, c columnDef) string {
+	t := baseType(dbType, c)
+	if t == "" {
+		return ""
+	}
+	switch c.kind {
+	case kindString:
+		t += " DEFAULT ''"
+	case kindInt:
+		t += " DEFAULT 0"
+	default:
+		return t
+	}
+	if dialects[dbType].notNull {
+		t += " NOT NULL"
+	}
+	return t
+}
+
+// isSupportedDBType reports whether the table SQL can be generated for the given database type.
+func isSupportedDBType(dbType string) bool {
+	_, ok := dialects[dbType]
+	return ok
+}
+
+// supportsIndexes reports whether indexes are created for the given database type.
+func supportsIndexes(dbType string) bool {
+	return dbType != "clickhouse"
+}
+
+// consistentReadOptions returns the options of a read-only transaction whose reads all see the same data,
+// and false if the database type has no such transaction.
+func consistentReadOptions(dbType string) (sql.TxOptions, bool) {
+	switch dbType {
+	case "mysql", "mariadb", "tidb", "pgsql", "sqlserver", "mssql", "dm":
+		return sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true}, true
+	case "oracle":
+		// A read-only transaction of Oracle reads the data committed when it started.
+		return sql.TxOptions{ReadOnly: true}, true
+	case "sqlite", "sqlite3":
+		// SQLite transactions are serializable, the drivers reject other isolation levels.
+		return sql.TxOptions{}, true
+	}
+	return sql.TxOptions{}, false
+}
+
+// indexName returns the index name of the given database type.
+// Index names are scoped to the table in MySQL, so the table name is only prepended for other databases.
+func indexName(dbType string, tableName string, idx indexDef) string {
+	switch dbType {
+	case "mysql", "mariadb", "tidb":
+		return idx.name
+	default:
+		return tableName + "_" + idx.name
+	}
+}
+
+// createTableSQL returns the CREATE TABLE statement of the given database type.
+// MySQL indexes are declared inline, other databases need the statements of createIndexSQL.
+func createTableSQL(dbType string, t tableDef) string {
+	if !isSupportedDBType(dbType) {
+		return ""
+	}
+	width := 0
+	for _, c := range t.columns {
+		if len(c.name) > width {
+			width = len(c.name)
+		}
+	}
+	lines := make([]string, 0, len(t.columns)+len(t.indexes))
+	for _, c := range t.columns {
+		lines = append(lines, fmt.Sprintf("    %-*s %s", width, c.name, columnType(dbType, c)))
+	}
+
+	var sb strings.Builder
+	sb.WriteString("CREATE TABLE ")
+	sb.WriteString(t.name)
+	sb.WriteString(" (\n")
+	switch dbType {
+	case "mysql", "mariadb", "tidb":
+		for _, idx := range t.indexes {
+			kind := "INDEX"
+			if idx.unique {
+				kind = "UNIQUE INDEX"
+			}
+			lines = append(lines, fmt.Sprintf("    %s %s (%s)", kind, indexName(dbType, t.name, idx), strings.Join(idx.columns, ", ")))
+		}
+		sb.WriteString(strings.Join(lines, ",\n"))
+		sb.WriteString(fmt.Sprintf("\n) COMMENT '%s'", t.comment))
+	case "clickhouse":
+		sb.WriteString(strings.Join(lines, ",\n"))
+		sb.WriteString("\n) ENGINE = MergeTree()\nORDER BY id")
+	default:
+		sb.WriteString(strings.Join(lines, ",\n"))
+		sb.WriteString("\n)")
+	}
+	return sb.String()
+}
+
+// createIndexSQL returns the CREATE INDEX statement of the given database type.
+func createIndexSQL(dbType string, tableName string, idx indexDef) string {
+	kind := "INDEX"
+	if idx.unique {
+		kind = "UNIQUE INDEX"
+	}
+	return fmt.Sprintf("CREATE %s %s ON %s (%s)", kind, indexName(dbType, tableName, idx), tableName, strings.Join(idx.columns, ", "))
+}
+
+// dropIndexSQL returns the DROP INDEX statement of the given database type.
+func dropIndexSQL(dbType string, tableName string, idx indexDef) string {
+	switch dbType {
+	case "mysql", "mariadb", "tidb", "sqlserver", "mssql":
+		return fmt.Sprintf("DROP INDEX %s ON %s", indexName(dbType, tableName, idx), tableName)
+	default:
+		return fmt.Sprintf("DROP INDEX %s", indexName(dbType, tableName, idx))
+	}
+}
+
+// indexExistsSQL returns the query counting the indexes with the given name on a table.
+// The query takes the table name and the index name as arguments.
+func indexExistsSQL(dbType string) string {
+	switch dbType {
+	case "mysql", "mariadb", "tidb":
+		return "SELECT COUNT(1) FROM information_schema.statistics WHERE table_schema = DATABASE() AND table_name = ? AND index_name = ?"
+	case "pgsql":
+		return "SELECT COUNT(1) FROM pg_indexes WHERE tablename = ? AND indexname = ?"
+	case "sqlite", "sqlite3":
+		return "SELECT COUNT(1) FROM sqlite_master WHERE type = 'index' AND tbl_name = ? AND name = ?"
+	case "sqlserver", "mssql":
+		return "SELECT COUNT(1) FROM sys.indexes WHERE object_id = OBJECT_ID(?) AND name = ?"
+	case "oracle", "dm":
+		return "SELECT COUNT(1) FROM user_indexes WHERE table_name = UPPER(?) AND index_name = UPPER(?)"
+	}
+	return ""
+}
+
+// tableStatements returns the statements creating the given table and its indexes.
+func tableStatements(dbType string, t tableDef) []string {
+	sql := createTableSQL(dbType, t)
+	if sql == "" {
+		return nil
+	}
+	statements := []string{sql}
+	switch dbType {
+	case "mysql", "mariadb", "tidb", "clickhouse":
+	default:
+		for _, idx := range t.indexes {
+			statements = append(statements, createIndexSQL(dbType, t.name, idx))
+		}
+	}
+	return statements
+}
+
+// addColumnSQL returns the statement adding the given column to a table.
+func addColumnSQL(dbType string, tableName string, c columnDef) string {
+	switch dbType {
+	case "sqlserver", "mssql":
+		return fmt.Sprintf("ALTER TABLE %s ADD %s %s", tableName, c.name, columnType(dbType, c))
+	case "oracle":
+		return fmt.Sprintf("ALTER TABLE %s ADD (%s %s)", tableName, c.name, columnType(dbType, c))
+	default:
+		return fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", tableName, c.name, columnType(dbType, c))
+	}
+}
+
+// alterColumnSQL returns the statement changing the type of the given column.
+// It returns an empty string if the column type does not depend on the column size.
+func alterColumnSQL(dbType string, tableName string, c columnDef) string {
+	if c.kind == kindString && !strings.Contains(dialects[dbType].varchar, "%d") {
+		return ""
+	}
+	switch dbType {
+	case "mysql", "mariadb", "tidb":
+		return fmt.Sprintf("ALTER TABLE %s MODIFY COLUMN %s %s", tableName, c.name, columnType(dbType, c))
+	case "pgsql":
+		return fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s TYPE %s", tableName, c.name, baseType(dbType, c))
+	case "sqlserver", "mssql":
+		return fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s %s NOT NULL", tableName, c.name, baseType(dbType, c))
+	case "oracle":
+		return fmt.Sprintf("ALTER TABLE %s MODIFY (%s %s)", tableName, c.name, baseType(dbType, c))
+	case "dm":
+		return fmt.Sprintf("ALTER TABLE %s MODIFY %s %s", tableName, c.name, baseType(dbType, c))
+	}
+	return ""
+}
+
+// The CREATE TABLE templates of the original rule table, with six value columns of 100 characters and no rule hash.
+// %s stands for the table name, see FillSQLTemplate. A table created from them needs Adapter.Migrate.
+//
+// Deprecated: use GetCreateTableSQLByTemplate, which follows the current schema.
 const (
 	CreateTableSQLMySQL = `
 CREATE TABLE %s (
@@ -153,35 +460,12 @@ func FillSQLTemplate(sqlTemplate string, tableName string) string {
 	return fmt.Sprintf(sqlTemplate, args...)
 }
 
+// GetCreateTableSQLByTemplate returns the script creating the casbin rule table for the given database type.
+// It returns an empty string if the database type is not supported.
 func GetCreateTableSQLByTemplate(dbType string, tableName string) string {
-	var sqlTemplate string
-
-	switch dbType {
-	case "mysql":
-		sqlTemplate = CreateTableSQLMySQL
-	case "mariadb":
-		sqlTemplate = CreateTableSQLMariaDB
-	case "pgsql":
-		sqlTemplate = CreateTableSQLPostgreSQL
-	case "sqlite":
-		sqlTemplate = CreateTableSQLSQLite
-	case "sqlite3":
-		sqlTemplate = CreateTableSQLSQLite3
-	case "sqlserver":
-		sqlTemplate = CreateTableSQLSQLServer
-	case "mssql":
-		sqlTemplate = CreateTableSQLMSSQL
-	case "oracle":
-		sqlTemplate = CreateTableSQLOracle
-	case "clickhouse":
-		sqlTemplate = CreateTableSQLClickHouse
-	case "dm":
-		sqlTemplate = CreateTableSQLDM
-	case "tidb":
-		sqlTemplate = CreateTableSQLTiDB
-	default:
+	statements := tableStatements(dbType, ruleTableDef(tableName, DefaultValueColumns, DefaultColumnLength))
+	if len(statements) == 0 {
 		return ""
 	}
-
-	return FillSQLTemplate(sqlTemplate, tableName)
+	return strings.Join(statements, ";\n") + ";"
 }
@@ -0,0 +1,82 @@
+package gfadapter
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/yclw/gf-casbin-adapter/dao"
+)
+
+func TestGetCreateTableSQLByTemplate(t *testing.T) {
+	sql := GetCreateTableSQLByTemplate("mysql", "casbin_rule")
+	assert.True(t, strings.HasPrefix(sql, "CREATE TABLE casbin_rule ("))
+	assert.Contains(t, sql, "v5        VARCHAR(255) DEFAULT '' NOT NULL")
+	assert.Contains(t, sql, "INDEX idx_ptype (ptype)")
+	assert.NotContains(t, sql, "CREATE INDEX")
+	assert.Contains(t, sql, "UNIQUE INDEX uk_rule_hash (rule_hash)")
+
+	sql = GetCreateTableSQLByTemplate("pgsql", "casbin_rule")
+	assert.Contains(t, sql, "id        BIGSERIAL PRIMARY KEY")
+	assert.Contains(t, sql, "CREATE INDEX casbin_rule_idx_v0 ON casbin_rule (v0);")
+
+	sql = GetCreateTableSQLByTemplate("clickhouse", "casbin_rule")
+	assert.Contains(t, sql, "ENGINE = MergeTree()")
+	assert.NotContains(t, sql, "INDEX")
+
+	assert.Equal(t, "", GetCreateTableSQLByTemplate("unknown", "casbin_rule"))
+}
+
+func TestTableStatements(t *testing.T) {
+	def := ruleTableDef("casbin_rule", DefaultValueColumns, DefaultColumnLength)
+
+	assert.Len(t, tableStatements("mysql", def), 1)
+	assert.Len(t, tableStatements("sqlite", def), 1+len(def.indexes))
+	assert.Nil(t, tableStatements("unknown", def))
+
+	assert.Equal(t, "idx_v1", indexName("mysql", "casbin_rule", def.indexes[2]))
+	assert.Equal(t, "casbin_rule_idx_v1", indexName("oracle", "casbin_rule", def.indexes[2]))
+}
+
+func TestRuleTableDef(t *testing.T) {
+	def := ruleTableDef("casbin_rule", 10, 1024)
+	assert.Len(t, def.columns, 13)
+	assert.Equal(t, "v9", def.columns[11].name)
+	assert.Equal(t, "rule_hash", def.columns[12].name)
+
+	sql := createTableSQL("pgsql", def)
+	assert.Contains(t, sql, "v9        VARCHAR(1024) DEFAULT '' NOT NULL")
+	assert.Equal(t, "ALTER TABLE casbin_rule ADD COLUMN v9 VARCHAR(1024) DEFAULT '' NOT NULL", addColumnSQL("mysql", "casbin_rule", def.columns[11]))
+	assert.Equal(t, "ALTER TABLE casbin_rule ADD (v9 VARCHAR2(1024) DEFAULT '' NOT NULL)", addColumnSQL("oracle", "casbin_rule", def.columns[11]))
+
+	assert.Equal(t, 100, fieldSize("character varying(100)"))
+	assert.Equal(t, 0, fieldSize("text"))
+}
+
+func TestTenantRuleTableDef(t *testing.T) {
+	a := &Adapter{
+		dao:          dao.NewCasbinRuleDaoWithName("casbin_rule"),
+		valueColumns: []string{"v0", "v1", "v2", "v3", "v4", "v5"},
+		columnLength: DefaultColumnLength,
+		tenantMode:   true,
+	}
+	def := a.ruleTableDef()
+	assert.Equal(t, "tenant_id", def.columns[len(def.columns)-1].name)
+	assert.Contains(t, def.indexes, tenantRuleHashIndex)
+	assert.NotContains(t, def.indexes, ruleHashIndex)
+
+	assert.Equal(t, "DROP INDEX uk_rule_hash ON casbin_rule", dropIndexSQL("mysql", "casbin_rule", ruleHashIndex))
+	assert.Equal(t, "DROP INDEX casbin_rule_uk_rule_hash", dropIndexSQL("pgsql", "casbin_rule", ruleHashIndex))
+	assert.Equal(t, "CREATE UNIQUE INDEX casbin_rule_uk_tenant_rule_hash ON casbin_rule (tenant_id, rule_hash)", createIndexSQL("sqlite", "casbin_rule", tenantRuleHashIndex))
+}
+
+func TestDeprecatedCreateTableSQL(t *testing.T) {
+	sql := FillSQLTemplate(CreateTableSQLPostgreSQL, "my_rule")
+	assert.Contains(t, sql, "CREATE TABLE my_rule (")
+	assert.Contains(t, sql, "v5    VARCHAR(100) DEFAULT '' NOT NULL")
+	assert.Contains(t, sql, "CREATE INDEX my_rule_idx_v0 ON my_rule (v0);")
+	assert.NotContains(t, sql, "rule_hash")
+	assert.NotContains(t, sql, "%")
+	assert.Equal(t, CreateTableSQLMySQL, CreateTableSQLMariaDB)
+}
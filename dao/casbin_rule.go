@@ -22,30 +22,38 @@ type CasbinRuleDao struct {
 	group    string             // group is the database configuration group name of the current DAO.
 	columns  CasbinRuleColumns  // columns contains all the column names of Table for convenient usage.
 	handlers []gdb.ModelHandler // handlers for customized model modification.
+	db       gdb.DB             // db is the injected database object, it takes precedence over group if not nil.
+	tx       gdb.TX             // tx is the transaction joined by all operations if not nil.
+	scope    ScopeFunc          // scope restricts the models of all operations if not nil.
 }
 
+// ScopeFunc restricts a model created by CasbinRuleDao.Ctx according to the context of the operation.
+type ScopeFunc func(ctx context.Context, model *gdb.Model) *gdb.Model
+
 // CasbinRuleColumns defines and stores column names for the table casbin_rule.
 type CasbinRuleColumns struct {
-	Id    string //
-	Ptype string //
-	V0    string //
-	V1    string //
-	V2    string //
-	V3    string //
-	V4    string //
-	V5    string //
+	Id       string //
+	Ptype    string //
+	V0       string //
+	V1       string //
+	V2       string //
+	V3       string //
+	V4       string //
+	V5       string //
+	RuleHash string //
 }
 
 // casbinRuleColumns holds the columns for the table casbin_rule.
 var casbinRuleColumns = CasbinRuleColumns{
-	Id:    "id",
-	Ptype: "ptype",
-	V0:    "v0",
-	V1:    "v1",
-	V2:    "v2",
-	V3:    "v3",
-	V4:    "v4",
-	V5:    "v5",
+	Id:       "id",
+	Ptype:    "ptype",
+	V0:       "v0",
+	V1:       "v1",
+	V2:       "v2",
+	V3:       "v3",
+	V4:       "v4",
+	V5:       "v5",
+	RuleHash: "rule_hash",
 }
 
 // NewCasbinRuleDao creates and returns a new DAO object for table data access.
@@ -68,11 +76,70 @@ func NewCasbinRuleDaoWithName(tableName string, handlers ...gdb.ModelHandler) *C
 	}
 }
 
+// NewCasbinRuleDaoWithGroup creates and returns a new DAO object using the given database configuration group.
+func NewCasbinRuleDaoWithGroup(group string, tableName string, handlers ...gdb.ModelHandler) *CasbinRuleDao {
+	return &CasbinRuleDao{
+		group:    group,
+		table:    tableName,
+		columns:  casbinRuleColumns,
+		handlers: handlers,
+	}
+}
+
+// NewCasbinRuleDaoWithDB creates and returns a new DAO object using the given database object.
+func NewCasbinRuleDaoWithDB(db gdb.DB, tableName string, handlers ...gdb.ModelHandler) *CasbinRuleDao {
+	return &CasbinRuleDao{
+		group:    db.GetGroup(),
+		table:    tableName,
+		columns:  casbinRuleColumns,
+		handlers: handlers,
+		db:       db,
+	}
+}
+
 // DB retrieves and returns the underlying raw database management object of the current DAO.
 func (dao *CasbinRuleDao) DB() gdb.DB {
+	if dao.db != nil {
+		return dao.db
+	}
 	return g.DB(dao.group)
 }
 
+// WithTX returns a copy of the current DAO whose operations join the given transaction.
+func (dao *CasbinRuleDao) WithTX(tx gdb.TX) *CasbinRuleDao {
+	c := *dao
+	c.tx = tx
+	return &c
+}
+
+// WithTable returns a copy of the current DAO operating on the given table.
+func (dao *CasbinRuleDao) WithTable(table string) *CasbinRuleDao {
+	c := *dao
+	c.table = table
+	return &c
+}
+
+// TX returns the transaction joined by all operations of the current DAO, or nil if there is none.
+func (dao *CasbinRuleDao) TX() gdb.TX {
+	return dao.tx
+}
+
+// WithScope returns a copy of the current DAO whose models are restricted by scope, nil removes the restriction.
+func (dao *CasbinRuleDao) WithScope(scope ScopeFunc) *CasbinRuleDao {
+	c := *dao
+	c.scope = scope
+	return &c
+}
+
+// TxCtx returns a context carrying the transaction of the current DAO, or ctx if there is none.
+// The transaction of the DAO replaces a transaction already carried by ctx.
+func (dao *CasbinRuleDao) TxCtx(ctx context.Context) context.Context {
+	if dao.tx == nil {
+		return ctx
+	}
+	return gdb.WithTX(gdb.WithoutTX(ctx, dao.tx.GetDB().GetGroup()), dao.tx)
+}
+
 // Table returns the table name of the current DAO.
 func (dao *CasbinRuleDao) Table() string {
 	return dao.table
@@ -94,7 +161,11 @@ func (dao *CasbinRuleDao) Ctx(ctx context.Context) *gdb.Model {
 	for _, handler := range dao.handlers {
 		model = handler(model)
 	}
-	return model.Safe().Ctx(ctx)
+	model = model.Safe().Ctx(dao.TxCtx(ctx))
+	if dao.scope != nil {
+		model = dao.scope(ctx, model)
+	}
+	return model
 }
 
 // Transaction wraps the transaction logic using function f.
@@ -103,6 +174,15 @@ func (dao *CasbinRuleDao) Ctx(ctx context.Context) *gdb.Model {
 //
 // Note: Do not commit or roll back the transaction in function f,
 // as it is automatically handled by this function.
+// A transaction carried by ctx or set by WithTX is joined instead of starting a new one.
 func (dao *CasbinRuleDao) Transaction(ctx context.Context, f func(ctx context.Context, tx gdb.TX) error) (err error) {
+	ctx = dao.TxCtx(ctx)
 	return dao.Ctx(ctx).Transaction(ctx, f)
 }
+
+// TransactionWithOptions is Transaction starting a new transaction with the given options.
+// A transaction carried by ctx or set by WithTX is joined as it is.
+func (dao *CasbinRuleDao) TransactionWithOptions(ctx context.Context, opts gdb.TxOptions, f func(ctx context.Context, tx gdb.TX) error) (err error) {
+	ctx = dao.TxCtx(ctx)
+	return dao.Ctx(ctx).TransactionWithOptions(ctx, opts, f)
+}
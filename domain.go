@@ -0,0 +1,136 @@
+package gfadapter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/casbin/casbin/v2/constant"
+	"github.com/casbin/casbin/v2/model"
+	"github.com/gogf/gf/v2/util/gconv"
+)
+
+// DomainFilter returns a filter loading the rules of the given domains.
+// The domain column of each ptype is taken from the model: the "dom" token of a policy definition,
+// or the index set by Enforcer.SetFieldIndex, and the third value of a role definition with at least three values.
+// The rules of the ptypes without a domain are not restricted.
+func DomainFilter(m model.Model, domains ...string) (Filter, error) {
+	if len(domains) == 0 {
+		return Filter{}, errors.New("no domain given")
+	}
+	sections := make(map[string]Cond)
+	for ptype, column := range domainColumns(m) {
+		sections[ptype] = Eq(column, domains...)
+	}
+	return Filter{Sections: sections}, nil
+}
+
+// TransitiveDomainFilter returns the filter of DomainFilter,
+// with the role links of the role definitions without a domain restricted to the links leading to the domains.
+// Such links, e.g. of "g = _, _" used together with domain policies, are otherwise loaded completely.
+// A link is loaded if its role is a subject of the domain rules or the member of a link already loaded,
+// so the inherited permissions are still resolved by the filtered enforcer.
+// Only the rules loaded by LoadPolicy are followed.
+func (a *Adapter) TransitiveDomainFilter(ctx context.Context, m model.Model, domains ...string) (Filter, error) {
+	a, err := a.forTenant(ctx)
+	if err != nil {
+		return Filter{}, err
+	}
+	filter, err := DomainFilter(m, domains...)
+	if err != nil {
+		return filter, err
+	}
+	ctx = a.withLoadNode(ctx)
+	columns := domainColumns(m)
+	var global []string
+	for ptype := range m["g"] {
+		if _, ok := columns[ptype]; !ok {
+			global = append(global, ptype)
+		}
+	}
+	if len(global) == 0 {
+		return filter, nil
+	}
+	sort.Strings(global)
+
+	cols := a.dao.Columns()
+	query := a.policyQuery(ctx)
+	names := make(map[string]bool)
+	var frontier []string
+	add := func(values []string) {
+		for _, v := range values {
+			if v != "" && !names[v] {
+				names[v] = true
+				frontier = append(frontier, v)
+			}
+		}
+	}
+
+	// The subjects of the domain rules and the users and roles linked in the domains start the search.
+	for ptype, column := range columns {
+		fields := []string{cols.V0}
+		if ptype[:1] == "g" {
+			fields = append(fields, cols.V1)
+		} else if index, err := m.GetFieldIndex(ptype, constant.SubjectIndex); err == nil {
+			fields = []string{fmt.Sprintf("v%d", index)}
+		}
+		for _, field := range fields {
+			values, err := query().
+				Fields(field).
+				Where(cols.Ptype, ptype).
+				WhereIn(column, domains).
+				Distinct().
+				Array()
+			if err != nil {
+				return filter, err
+			}
+			add(gconv.Strings(values))
+		}
+	}
+
+	// Follow the links from roles to their members until no new member is found.
+	for len(frontier) > 0 {
+		roles := frontier
+		frontier = nil
+		for start := 0; start < len(roles); start += flushEvery {
+			end := min(start+flushEvery, len(roles))
+			values, err := query().
+				Fields(cols.V0).
+				WhereIn(cols.Ptype, global).
+				WhereIn(cols.V1, roles[start:end]).
+				Distinct().
+				Array()
+			if err != nil {
+				return filter, err
+			}
+			add(gconv.Strings(values))
+		}
+	}
+
+	roles := make([]string, 0, len(names))
+	for name := range names {
+		roles = append(roles, name)
+	}
+	sort.Strings(roles)
+	for _, ptype := range global {
+		filter.Sections[ptype] = Eq(cols.V1, roles...)
+	}
+	return filter, nil
+}
+
+// domainColumns returns the domain column of each ptype of the model having a domain.
+func domainColumns(m model.Model) map[string]string {
+	columns := make(map[string]string)
+	for ptype := range m["p"] {
+		if index, err := m.GetFieldIndex(ptype, constant.DomainIndex); err == nil {
+			columns[ptype] = fmt.Sprintf("v%d", index)
+		}
+	}
+	for ptype, ast := range m["g"] {
+		if len(ast.Tokens) >= 3 {
+			columns[ptype] = "v2"
+		}
+	}
+	return columns
+}
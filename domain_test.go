@@ -0,0 +1,196 @@
+package gfadapter
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/casbin/casbin/v2"
+	"github.com/casbin/casbin/v2/model"
+	"github.com/stretchr/testify/assert"
+)
+
+// globalRolesModel uses role links without a domain together with domain policies.
+const globalRolesModel = `
+[request_definition]
+r = sub, dom, obj, act
+
+[policy_definition]
+p = sub, dom, obj, act
+
+[role_definition]
+g = _, _
+
+[policy_effect]
+e = some(where (p.eft == allow))
+
+[matchers]
+m = g(r.sub, p.sub) && r.dom == p.dom && r.obj == p.obj && r.act == p.act
+`
+
+func TestDomainFilter(t *testing.T) {
+	m, err := model.NewModelFromFile("examples/rbac_with_domains_model.conf")
+	assert.Nil(t, err)
+
+	filter, err := DomainFilter(m, "domain1")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]Cond{
+		"p": Eq("v1", "domain1"),
+		"g": Eq("v2", "domain1"),
+	}, filter.Sections)
+
+	_, err = DomainFilter(m)
+	assert.NotNil(t, err)
+
+	m, err = model.NewModelFromString(globalRolesModel)
+	assert.Nil(t, err)
+	filter, err = DomainFilter(m, "domain1")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]Cond{"p": Eq("v1", "domain1")}, filter.Sections)
+}
+
+func TestLoadDomainPolicy(t *testing.T) {
+	a, err := NewAdapterWithOptions(WithTableName("test_casbin_rule_domain"), WithAutoCreateTable(true))
+	assert.Nil(t, err)
+	e, err := casbin.NewEnforcer("examples/rbac_with_domains_model.conf", "examples/rbac_with_domains_policy.csv")
+	assert.Nil(t, err)
+	assert.Nil(t, a.SavePolicy(e.GetModel()))
+
+	e, err = casbin.NewEnforcer("examples/rbac_with_domains_model.conf", a)
+	assert.Nil(t, err)
+	filter, err := DomainFilter(e.GetModel(), "domain1")
+	assert.Nil(t, err)
+	assert.Nil(t, e.LoadFilteredPolicy(filter))
+	testGetPolicy(t, e, [][]string{{"admin", "domain1", "data1", "read"}, {"admin", "domain1", "data1", "write"}})
+	ok, err := e.Enforce("alice", "domain1", "data1", "read")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	grouping, _ := e.GetGroupingPolicy()
+	assert.Equal(t, [][]string{{"alice", "admin", "domain1"}}, grouping)
+}
+
+func TestTransitiveDomainFilter(t *testing.T) {
+	a, err := NewAdapterWithOptions(WithTableName("test_casbin_rule_domain_roles"), WithAutoCreateTable(true))
+	assert.Nil(t, err)
+	m, err := model.NewModelFromString(globalRolesModel)
+	assert.Nil(t, err)
+	e, err := casbin.NewEnforcer(m)
+	assert.Nil(t, err)
+	_, err = e.AddPolicies([][]string{{"admin", "domain1", "data1", "read"}, {"other", "domain2", "data2", "read"}})
+	assert.Nil(t, err)
+	_, err = e.AddGroupingPolicies([][]string{{"alice", "staff"}, {"staff", "admin"}, {"bob", "other"}})
+	assert.Nil(t, err)
+	assert.Nil(t, a.SavePolicy(e.GetModel()))
+
+	e, err = casbin.NewEnforcer(m, a)
+	assert.Nil(t, err)
+	filter, err := a.TransitiveDomainFilter(context.Background(), e.GetModel(), "domain1")
+	assert.Nil(t, err)
+	assert.Nil(t, e.LoadFilteredPolicy(filter))
+	grouping, _ := e.GetGroupingPolicy()
+	assert.True(t, arrayEqualsWithoutOrder([][]string{{"alice", "staff"}, {"staff", "admin"}}, grouping))
+	ok, err := e.Enforce("alice", "domain1", "data1", "read")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+}
+
+func TestTransitiveDomainFilterValid(t *testing.T) {
+	a, err := NewAdapterWithOptions(
+		WithTableName("test_casbin_rule_domain_valid"),
+		WithAutoCreateTable(true),
+		WithValidityWindows(true),
+	)
+	assert.Nil(t, err)
+	ctx := context.Background()
+	_, err = a.dao.Ctx(ctx).WhereGT(a.dao.Columns().Id, 0).Delete()
+	assert.Nil(t, err)
+	m, err := model.NewModelFromString(globalRolesModel)
+	assert.Nil(t, err)
+	e, err := casbin.NewEnforcer(m, a)
+	assert.Nil(t, err)
+	_, err = e.AddPolicy("admin", "domain1", "data1", "read")
+	assert.Nil(t, err)
+	_, err = e.AddGroupingPolicies([][]string{{"alice", "staff"}, {"bob", "other"}})
+	assert.Nil(t, err)
+	expired := time.Now().Add(-time.Minute)
+	assert.Nil(t, a.AddPolicyWithExpiryCtx(ctx, "g", "g", []string{"staff", "admin"}, time.Time{}, expired))
+	assert.Nil(t, a.AddPolicyWithExpiryCtx(ctx, "p", "p", []string{"other", "domain1", "data2", "read"}, time.Time{}, expired))
+
+	// The expired rule and link do not lead to the links of alice and bob.
+	filter, err := a.TransitiveDomainFilter(ctx, e.GetModel(), "domain1")
+	assert.Nil(t, err)
+	assert.Nil(t, e.LoadFilteredPolicy(filter))
+	testGetPolicy(t, e, [][]string{{"admin", "domain1", "data1", "read"}})
+	grouping, _ := e.GetGroupingPolicy()
+	assert.Empty(t, grouping)
+}
+
+func TestLoadSubjectPolicy(t *testing.T) {
+	a := initAdapter(t)
+	ctx := context.Background()
+
+	m, err := model.NewModelFromFile("examples/rbac_model.conf")
+	assert.Nil(t, err)
+	assert.Nil(t, a.LoadSubjectPolicy(ctx, m, "alice"))
+	policy, _ := m.GetPolicy("p", "p")
+	assert.True(t, arrayEqualsWithoutOrder([][]string{{"alice", "data1", "read"}, {"data2_admin", "data2", "read"}, {"data2_admin", "data2", "write"}}, policy))
+	grouping, _ := m.GetPolicy("g", "g")
+	assert.Equal(t, [][]string{{"alice", "data2_admin"}}, grouping)
+	assert.True(t, a.IsFiltered())
+
+	m, err = model.NewModelFromFile("examples/rbac_model.conf")
+	assert.Nil(t, err)
+	assert.Nil(t, a.LoadSubjectPolicy(ctx, m, "bob"))
+	policy, _ = m.GetPolicy("p", "p")
+	assert.Equal(t, [][]string{{"bob", "data2", "write"}}, policy)
+}
+
+func TestLoadSubjectPolicyValid(t *testing.T) {
+	a, err := NewAdapterWithOptions(
+		WithTableName("test_casbin_rule_subject_valid"),
+		WithAutoCreateTable(true),
+		WithValidityWindows(true),
+		WithSoftDelete(true),
+	)
+	assert.Nil(t, err)
+	ctx := context.Background()
+	_, err = a.dao.Ctx(ctx).Unscoped().WhereGT(a.dao.Columns().Id, 0).Delete()
+	assert.Nil(t, err)
+	initPolicy(t, a)
+	assert.Nil(t, a.RemovePolicyCtx(ctx, "g", "g", []string{"alice", "data2_admin"}))
+	assert.Nil(t, a.AddPolicyWithExpiryCtx(ctx, "g", "g", []string{"bob", "data2_admin"}, time.Time{}, time.Now().Add(-time.Minute)))
+
+	// The roles are not followed through deleted links or links outside their window.
+	m, err := model.NewModelFromFile("examples/rbac_model.conf")
+	assert.Nil(t, err)
+	assert.Nil(t, a.LoadSubjectPolicy(ctx, m, "alice"))
+	policy, _ := m.GetPolicy("p", "p")
+	assert.Equal(t, [][]string{{"alice", "data1", "read"}}, policy)
+	grouping, _ := m.GetPolicy("g", "g")
+	assert.Empty(t, grouping)
+
+	m, err = model.NewModelFromFile("examples/rbac_model.conf")
+	assert.Nil(t, err)
+	assert.Nil(t, a.LoadSubjectPolicy(ctx, m, "bob"))
+	policy, _ = m.GetPolicy("p", "p")
+	assert.Equal(t, [][]string{{"bob", "data2", "write"}}, policy)
+	grouping, _ = m.GetPolicy("g", "g")
+	assert.Empty(t, grouping)
+}
+
+func TestLoadSubjectPolicyWithDomain(t *testing.T) {
+	a, err := NewAdapterWithOptions(WithTableName("test_casbin_rule_domain"), WithAutoCreateTable(true))
+	assert.Nil(t, err)
+	e, err := casbin.NewEnforcer("examples/rbac_with_domains_model.conf", "examples/rbac_with_domains_policy.csv")
+	assert.Nil(t, err)
+	assert.Nil(t, a.SavePolicy(e.GetModel()))
+
+	e, err = casbin.NewEnforcer("examples/rbac_with_domains_model.conf")
+	assert.Nil(t, err)
+	assert.Nil(t, a.LoadSubjectPolicy(context.Background(), e.GetModel(), "alice", "domain1"))
+	assert.Nil(t, e.BuildRoleLinks())
+	testGetPolicy(t, e, [][]string{{"admin", "domain1", "data1", "read"}, {"admin", "domain1", "data1", "write"}})
+	ok, err := e.Enforce("alice", "domain1", "data1", "write")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+}
@@ -0,0 +1,234 @@
+package gfadapter
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/gogf/gf/v2/database/gdb"
+	"github.com/gogf/gf/v2/encoding/gyaml"
+)
+
+// ExportFormat is the format of the rules written by Adapter.Export.
+type ExportFormat string
+
+const (
+	// ExportCSV writes one rule per line like the policy files of casbin, e.g. "p, alice, data1, read".
+	ExportCSV ExportFormat = "csv"
+	// ExportJSON writes an array of objects like {"ptype": "p", "rule": ["alice", "data1", "read"]}.
+	ExportJSON ExportFormat = "json"
+	// ExportYAML writes a sequence of mappings with the ptype and rule keys of ExportJSON.
+	ExportYAML ExportFormat = "yaml"
+)
+
+// exportedRule is a rule written by Export in JSON or YAML.
+type exportedRule struct {
+	Ptype string   `json:"ptype" yaml:"ptype"`
+	Rule  []string `json:"rule" yaml:"rule"`
+}
+
+// ruleWriter writes the rules of an export in one format.
+type ruleWriter interface {
+	// write writes a policy line.
+	write(line []string) error
+	// close completes the output after the last line.
+	close() error
+}
+
+// Export writes the rules matching the filter, or all rules if no filter is given, to w in the given format.
+// The rules are ordered by their rule hash, so exports of the same rules are identical whatever order they were added in
+// and whatever collation the database uses, the hash being a lowercase hex string.
+// They are read loadPageSize rows at once through the unique index of the rule hash, in one read-only transaction like LoadPolicy.
+// Rules outside their validity window are skipped.
+func (a *Adapter) Export(ctx context.Context, w io.Writer, format ExportFormat, filter ...Filter) error {
+	a, err := a.forTenant(ctx)
+	if err != nil {
+		return err
+	}
+	if len(filter) > 1 {
+		return errors.New("at most one filter can be given")
+	}
+	ctx = a.withLoadNode(ctx)
+	query := a.policyQuery(ctx)
+	if len(filter) == 1 {
+		if query, err = a.filterQuery(ctx, filter[0]); err != nil {
+			return err
+		}
+	}
+
+	buf := bufio.NewWriter(w)
+	var rw ruleWriter
+	switch format {
+	case ExportCSV:
+		rw = &csvRuleWriter{w: buf}
+	case ExportJSON:
+		rw = &jsonRuleWriter{w: buf}
+	case ExportYAML:
+		rw = &yamlRuleWriter{w: buf}
+	default:
+		return fmt.Errorf("unsupported export format %q", format)
+	}
+
+	cols := a.dao.Columns()
+	fields := append([]string{cols.Id, cols.RuleHash}, a.ruleFields()...)
+	err = a.readConsistent(ctx, query, func(query func() *gdb.Model) error {
+		return a.eachSortedPage(func() *gdb.Model {
+			return query().Fields(fields)
+		}, a.loadPageSize, func(result gdb.Result) error {
+			for _, line := range a.policyLines(result) {
+				if err := rw.write(line); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return err
+	}
+	if err = rw.close(); err != nil {
+		return err
+	}
+	return buf.Flush()
+}
+
+// eachSortedPage reads the rows selected by query in pages of pageSize rows ordered by rule hash
+// and calls f for every page, like eachPage. Each page starts after the last row of the previous one,
+// the id breaks the ties of the same rule of several tenants read without the tenant scope.
+// query must return a new model selecting the id and the rule hash on every call.
+func (a *Adapter) eachSortedPage(query func() *gdb.Model, pageSize int, f func(result gdb.Result) error) error {
+	cols := a.dao.Columns()
+	columns := []string{cols.RuleHash, cols.Id}
+	var last gdb.Record
+	for {
+		m := query()
+		if last != nil {
+			cond, args := a.afterCond(columns, last)
+			m = m.Where(cond, args...)
+		}
+		for _, column := range columns {
+			m = m.OrderAsc(column)
+		}
+		result, err := m.Limit(pageSize).All()
+		if err != nil {
+			return err
+		}
+		if len(result) == 0 {
+			return nil
+		}
+		if err = f(result); err != nil {
+			return err
+		}
+		if len(result) < pageSize {
+			return nil
+		}
+		last = result[len(result)-1]
+	}
+}
+
+// afterCond returns the condition selecting the rows after record in the order of columns,
+// e.g. "((rule_hash > ?) OR (rule_hash = ? AND id > ?))" for the columns rule_hash and id.
+func (a *Adapter) afterCond(columns []string, record gdb.Record) (string, []interface{}) {
+	var (
+		terms []string
+		args  []interface{}
+	)
+	for i, column := range columns {
+		var term []string
+		for _, prev := range columns[:i] {
+			term = append(term, a.quote(prev)+" = ?")
+			args = append(args, record[prev].Val())
+		}
+		term = append(term, a.quote(column)+" > ?")
+		args = append(args, record[column].Val())
+		terms = append(terms, "("+strings.Join(term, " AND ")+")")
+	}
+	return "(" + strings.Join(terms, " OR ") + ")", args
+}
+
+// csvRuleWriter writes the rules like the policy files of casbin.
+type csvRuleWriter struct {
+	w *bufio.Writer
+}
+
+func (c *csvRuleWriter) write(line []string) error {
+	fields := make([]string, len(line))
+	for i, field := range line {
+		fields[i] = csvField(field)
+	}
+	_, err := c.w.WriteString(strings.Join(fields, ", ") + "\n")
+	return err
+}
+
+func (c *csvRuleWriter) close() error {
+	return nil
+}
+
+// csvField quotes a value that casbin would not read back unchanged otherwise.
+func csvField(value string) string {
+	if value == "" || !strings.ContainsAny(value, ",\"\r\n") && !strings.HasPrefix(value, " ") && !strings.HasPrefix(value, "\t") {
+		return value
+	}
+	return `"` + strings.ReplaceAll(value, `"`, `""`) + `"`
+}
+
+// jsonRuleWriter writes the rules as a JSON array.
+type jsonRuleWriter struct {
+	w     *bufio.Writer
+	count int
+}
+
+func (j *jsonRuleWriter) write(line []string) error {
+	data, err := json.Marshal(exportedRule{Ptype: line[0], Rule: line[1:]})
+	if err != nil {
+		return err
+	}
+	sep := ",\n  "
+	if j.count == 0 {
+		sep = "[\n  "
+	}
+	j.count++
+	if _, err = j.w.WriteString(sep); err != nil {
+		return err
+	}
+	_, err = j.w.Write(data)
+	return err
+}
+
+func (j *jsonRuleWriter) close() error {
+	end := "\n]\n"
+	if j.count == 0 {
+		end = "[]\n"
+	}
+	_, err := j.w.WriteString(end)
+	return err
+}
+
+// yamlRuleWriter writes the rules as a YAML sequence.
+type yamlRuleWriter struct {
+	w     *bufio.Writer
+	count int
+}
+
+func (y *yamlRuleWriter) write(line []string) error {
+	// Every rule is encoded as a sequence of one item, the items together make the sequence of all rules.
+	data, err := gyaml.Encode([]exportedRule{{Ptype: line[0], Rule: line[1:]}})
+	if err != nil {
+		return err
+	}
+	y.count++
+	_, err = y.w.Write(data)
+	return err
+}
+
+func (y *yamlRuleWriter) close() error {
+	if y.count > 0 {
+		return nil
+	}
+	_, err := y.w.WriteString("[]\n")
+	return err
+}
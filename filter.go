@@ -0,0 +1,245 @@
+package gfadapter
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Cond is a condition on the columns of the rule table selecting the rules loaded by LoadFilteredPolicy.
+// Columns are named like in the table, "ptype" and "v0" to "vn".
+type Cond interface {
+	// build returns the SQL of the condition with its arguments, an empty SQL matches all rules.
+	build(b *condBuilder) (string, []interface{}, error)
+}
+
+// condBuilder holds what is needed to turn conditions into SQL for a database type.
+type condBuilder struct {
+	dbType  string
+	quote   func(column string) string
+	columns map[string]bool
+}
+
+// column returns the quoted column, only the rule columns are accepted.
+func (b *condBuilder) column(name string) (string, error) {
+	if !b.columns[name] {
+		return "", fmt.Errorf("unknown filter column %q", name)
+	}
+	return b.quote(name), nil
+}
+
+// Eq matches the rules whose column equals one of the values.
+func Eq(column string, values ...string) Cond {
+	return inCond{column: column, values: values}
+}
+
+// NotIn matches the rules whose column equals none of the values.
+func NotIn(column string, values ...string) Cond {
+	return inCond{column: column, values: values, not: true}
+}
+
+// HasPrefix matches the rules whose column starts with prefix.
+func HasPrefix(column string, prefix string) Cond {
+	return likeCond{column: column, value: prefix, suffix: "%"}
+}
+
+// HasSuffix matches the rules whose column ends with suffix.
+func HasSuffix(column string, suffix string) Cond {
+	return likeCond{column: column, prefix: "%", value: suffix}
+}
+
+// Contains matches the rules whose column contains s.
+func Contains(column string, s string) Cond {
+	return likeCond{column: column, prefix: "%", value: s, suffix: "%"}
+}
+
+// IsEmpty matches the rules whose column is empty.
+func IsEmpty(column string) Cond {
+	return emptyCond{column: column}
+}
+
+// And matches the rules matching all conditions, it matches all rules if there is no condition.
+func And(conds ...Cond) Cond {
+	return groupCond{conds: conds}
+}
+
+// Or matches the rules matching any of the conditions, it matches no rule if there is no condition.
+func Or(conds ...Cond) Cond {
+	return groupCond{conds: conds, or: true}
+}
+
+// Not matches the rules not matching the condition.
+func Not(cond Cond) Cond {
+	return notCond{cond: cond}
+}
+
+// inCond is the condition of Eq and NotIn.
+type inCond struct {
+	column string
+	values []string
+	not    bool
+}
+
+func (c inCond) build(b *condBuilder) (string, []interface{}, error) {
+	column, err := b.column(c.column)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(c.values) == 0 {
+		if c.not {
+			return "1=1", nil, nil
+		}
+		return "1=0", nil, nil
+	}
+	args := make([]interface{}, 0, len(c.values))
+	for _, v := range c.values {
+		args = append(args, v)
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(c.values)), ",")
+	if c.not {
+		return fmt.Sprintf("%s NOT IN (%s)", column, placeholders), args, nil
+	}
+	return fmt.Sprintf("%s IN (%s)", column, placeholders), args, nil
+}
+
+// likeCond is the condition of HasPrefix, HasSuffix and Contains.
+// value is matched literally, prefix and suffix are wildcards.
+type likeCond struct {
+	column string
+	prefix string
+	value  string
+	suffix string
+}
+
+func (c likeCond) build(b *condBuilder) (string, []interface{}, error) {
+	column, err := b.column(c.column)
+	if err != nil {
+		return "", nil, err
+	}
+	// ClickHouse has no ESCAPE clause and always escapes with a backslash.
+	if b.dbType == "clickhouse" {
+		return column + " LIKE ?", []interface{}{c.prefix + escapeLike(c.value, '\\') + c.suffix}, nil
+	}
+	return column + " LIKE ? ESCAPE '!'", []interface{}{c.prefix + escapeLike(c.value, '!') + c.suffix}, nil
+}
+
+// escapeLike escapes the wildcards of a LIKE pattern with the given escape character.
+func escapeLike(s string, escape rune) string {
+	var sb strings.Builder
+	for _, r := range s {
+		if r == escape || r == '%' || r == '_' {
+			sb.WriteRune(escape)
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
+
+// emptyCond is the condition of IsEmpty.
+type emptyCond struct {
+	column string
+}
+
+func (c emptyCond) build(b *condBuilder) (string, []interface{}, error) {
+	column, err := b.column(c.column)
+	if err != nil {
+		return "", nil, err
+	}
+	// Oracle stores empty strings as NULL.
+	return fmt.Sprintf("(%s = '' OR %s IS NULL)", column, column), nil, nil
+}
+
+// groupCond is the condition of And and Or.
+type groupCond struct {
+	conds []Cond
+	or    bool
+}
+
+func (c groupCond) build(b *condBuilder) (string, []interface{}, error) {
+	var (
+		parts []string
+		args  []interface{}
+	)
+	for _, cond := range c.conds {
+		if cond == nil {
+			continue
+		}
+		sql, condArgs, err := cond.build(b)
+		if err != nil {
+			return "", nil, err
+		}
+		if sql == "" {
+			// A condition matching all rules makes an OR group match all rules too.
+			if c.or {
+				return "", nil, nil
+			}
+			continue
+		}
+		parts = append(parts, "("+sql+")")
+		args = append(args, condArgs...)
+	}
+	if len(parts) == 0 {
+		if c.or {
+			return "1=0", nil, nil
+		}
+		return "", nil, nil
+	}
+	if c.or {
+		return strings.Join(parts, " OR "), args, nil
+	}
+	return strings.Join(parts, " AND "), args, nil
+}
+
+// notCond is the condition of Not.
+type notCond struct {
+	cond Cond
+}
+
+func (c notCond) build(b *condBuilder) (string, []interface{}, error) {
+	if c.cond == nil {
+		return "1=0", nil, nil
+	}
+	sql, args, err := c.cond.build(b)
+	if err != nil {
+		return "", nil, err
+	}
+	if sql == "" {
+		return "1=0", nil, nil
+	}
+	return "NOT (" + sql + ")", args, nil
+}
+
+// sectionsCond returns the condition applying the condition of each section or ptype to its rules.
+// Keys "p" and "g" stand for all ptypes of their section without a condition of their own,
+// other keys are ptypes. The rules of the sections and ptypes without a condition are not restricted.
+func sectionsCond(sections map[string]Cond) Cond {
+	if len(sections) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(sections))
+	for key := range sections {
+		if key != "" && key != "p" && key != "g" {
+			keys = append(keys, key)
+		}
+	}
+	// Sorted keys keep the SQL the same between calls.
+	sort.Strings(keys)
+	ptypes := make(map[string][]string)
+	var conds, covered []Cond
+	for _, key := range keys {
+		ptypes[key[:1]] = append(ptypes[key[:1]], key)
+		conds = append(conds, And(Eq("ptype", key), sections[key]))
+		covered = append(covered, Eq("ptype", key))
+	}
+	for _, sec := range []string{"p", "g"} {
+		cond, ok := sections[sec]
+		if !ok {
+			continue
+		}
+		section := And(HasPrefix("ptype", sec), NotIn("ptype", ptypes[sec]...))
+		conds = append(conds, And(section, cond))
+		covered = append(covered, section)
+	}
+	conds = append(conds, Not(Or(covered...)))
+	return Or(conds...)
+}
@@ -0,0 +1,120 @@
+package gfadapter
+
+import (
+	"testing"
+
+	"github.com/casbin/casbin/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func testCondBuilder(dbType string) *condBuilder {
+	return &condBuilder{
+		dbType: dbType,
+		quote: func(column string) string {
+			return "`" + column + "`"
+		},
+		columns: map[string]bool{"ptype": true, "v0": true, "v1": true, "v2": true},
+	}
+}
+
+func TestCondBuild(t *testing.T) {
+	b := testCondBuilder("mysql")
+	tests := []struct {
+		cond Cond
+		sql  string
+		args []interface{}
+	}{
+		{Eq("v0", "alice", "bob"), "`v0` IN (?,?)", []interface{}{"alice", "bob"}},
+		{NotIn("v0", "guest"), "`v0` NOT IN (?)", []interface{}{"guest"}},
+		{Eq("v0"), "1=0", nil},
+		{HasPrefix("v1", "/api/orders/"), "`v1` LIKE ? ESCAPE '!'", []interface{}{"/api/orders/%"}},
+		{HasSuffix("v1", "100%"), "`v1` LIKE ? ESCAPE '!'", []interface{}{"%100!%"}},
+		{Contains("v1", "a_b!"), "`v1` LIKE ? ESCAPE '!'", []interface{}{"%a!_b!!%"}},
+		{IsEmpty("v2"), "(`v2` = '' OR `v2` IS NULL)", nil},
+		{
+			And(Eq("ptype", "p"), Or(HasPrefix("v1", "/a"), Not(Eq("v0", "bob")))),
+			"(`ptype` IN (?)) AND ((`v1` LIKE ? ESCAPE '!') OR (NOT (`v0` IN (?))))",
+			[]interface{}{"p", "/a%", "bob"},
+		},
+		{And(), "", nil},
+		{Or(), "1=0", nil},
+		{Or(Eq("v0", "alice"), And()), "", nil},
+		{Not(And()), "1=0", nil},
+	}
+	for _, test := range tests {
+		sql, args, err := test.cond.build(b)
+		assert.Nil(t, err)
+		assert.Equal(t, test.sql, sql)
+		assert.Equal(t, test.args, args)
+	}
+
+	_, _, err := And(Eq("v0", "alice"), Eq("v9; DROP TABLE casbin_rule", "x")).build(b)
+	assert.NotNil(t, err)
+
+	sql, args, err := HasPrefix("v1", `a\b`).build(testCondBuilder("clickhouse"))
+	assert.Nil(t, err)
+	assert.Equal(t, "`v1` LIKE ?", sql)
+	assert.Equal(t, []interface{}{`a\\b%`}, args)
+}
+
+func TestSectionsCond(t *testing.T) {
+	b := testCondBuilder("mysql")
+	sql, args, err := sectionsCond(map[string]Cond{
+		"p":  Eq("v0", "alice"),
+		"g2": Eq("v1", "domain1"),
+	}).build(b)
+	assert.Nil(t, err)
+	assert.Equal(t, "((`ptype` IN (?)) AND (`v1` IN (?)))"+
+		" OR (((`ptype` LIKE ? ESCAPE '!') AND (1=1)) AND (`v0` IN (?)))"+
+		" OR (NOT ((`ptype` IN (?)) OR ((`ptype` LIKE ? ESCAPE '!') AND (1=1))))", sql)
+	assert.Equal(t, []interface{}{"g2", "domain1", "p%", "alice", "g2", "p%"}, args)
+}
+
+func TestLoadFilteredPolicyCond(t *testing.T) {
+	a := initAdapter(t)
+	e, _ := casbin.NewEnforcer("examples/rbac_model.conf", a)
+
+	assert.Nil(t, e.LoadFilteredPolicy(Filter{Cond: And(HasPrefix("v0", "data2_"), NotIn("v2", "read"))}))
+	testGetPolicy(t, e, [][]string{{"data2_admin", "data2", "write"}})
+
+	assert.Nil(t, e.LoadFilteredPolicy(Filter{
+		Sections: map[string]Cond{
+			"p": Eq("v0", "bob"),
+		},
+	}))
+	testGetPolicy(t, e, [][]string{{"bob", "data2", "write"}})
+	grouping, _ := e.GetGroupingPolicy()
+	assert.Equal(t, [][]string{{"alice", "data2_admin"}}, grouping)
+
+	assert.Nil(t, e.LoadFilteredPolicy(Or(Eq("v0", "alice"), IsEmpty("v2"))))
+	testGetPolicy(t, e, [][]string{{"alice", "data1", "read"}})
+
+	assert.NotNil(t, e.LoadFilteredPolicy(Filter{Cond: Eq("unknown", "x")}))
+}
+
+func TestLoadIncrementalFilteredPolicy(t *testing.T) {
+	a := initAdapter(t)
+	e, _ := casbin.NewEnforcer("examples/rbac_model.conf", a)
+	assert.False(t, a.IsFiltered())
+
+	assert.Nil(t, e.LoadFilteredPolicy(Filter{V0: []string{"alice"}}))
+	assert.True(t, a.IsFiltered())
+	assert.Nil(t, e.LoadIncrementalFilteredPolicy(Filter{V0: []string{"bob"}}))
+	assert.True(t, a.IsFiltered())
+	testGetPolicy(t, e, [][]string{{"alice", "data1", "read"}, {"bob", "data2", "write"}})
+
+	// A full load is not filtered any more, adding filtered rules to it keeps it complete.
+	assert.Nil(t, e.LoadPolicy())
+	assert.False(t, a.IsFiltered())
+	assert.Nil(t, e.LoadIncrementalFilteredPolicy(Filter{V0: []string{"alice"}}))
+	assert.False(t, a.IsFiltered())
+	assert.Nil(t, e.SavePolicy())
+
+	// Rules can be added to a model directly.
+	m := e.GetModel()
+	m.ClearPolicy()
+	assert.Nil(t, a.LoadIncrementalFilteredPolicy(m, Filter{V0: []string{"bob"}}))
+	assert.True(t, a.IsFiltered())
+	assert.Nil(t, a.LoadIncrementalFilteredPolicy(m, Filter{V0: []string{"data2_admin"}}))
+	testGetPolicy(t, e, [][]string{{"bob", "data2", "write"}, {"data2_admin", "data2", "read"}, {"data2_admin", "data2", "write"}})
+}
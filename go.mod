@@ -3,7 +3,7 @@ module github.com/yclw/gf-casbin-adapter
 go 1.24.2
 
 require (
-	github.com/casbin/casbin/v2 v2.115.0
+	github.com/casbin/casbin/v2 v2.123.0
 	github.com/gogf/gf/contrib/drivers/mysql/v2 v2.9.0
 	github.com/gogf/gf/v2 v2.9.0
 	github.com/stretchr/testify v1.10.0
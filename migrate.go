@@ -0,0 +1,657 @@
+package gfadapter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gogf/gf/v2/database/gdb"
+	"github.com/gogf/gf/v2/frame/g"
+)
+
+// DefaultMigrationTableName is the name of the table recording the schema versions.
+const DefaultMigrationTableName = "casbin_schema_migrations"
+
+// ErrSchemaOutdated is matched by the error returned when creating an adapter whose rule table needs Migrate.
+var ErrSchemaOutdated = errors.New("the rule table needs to be migrated, run Migrate")
+
+// migration upgrades the rule table from the previous version to version.
+type migration struct {
+	version     int
+	description string
+	// plan returns the steps of the migration for the rule table with the given columns.
+	plan func(a *Adapter, dbType string, fields map[string]*gdb.TableField) []migrationStep
+}
+
+// migrationStep is either a statement or a data change done in Go.
+type migrationStep struct {
+	sql string
+	// run changes the data of the table, it is described by sql in a dry run.
+	run func(ctx context.Context, a *Adapter) error
+	// done reports whether the change of the step is already in place, e.g. after a failed run.
+	// Steps without it are safe to be repeated.
+	done func(ctx context.Context, db gdb.DB, tableName string) (bool, error)
+}
+
+// columnExists returns a check whether the table has the given column.
+func columnExists(column string) func(ctx context.Context, db gdb.DB, tableName string) (bool, error) {
+	return func(ctx context.Context, db gdb.DB, tableName string) (bool, error) {
+		fields, err := db.TableFields(ctx, tableName)
+		if err != nil {
+			return false, err
+		}
+		return tableField(fields, column) != nil, nil
+	}
+}
+
+// indexCreated returns a check whether the table has the given index.
+func indexCreated(idx indexDef) func(ctx context.Context, db gdb.DB, tableName string) (bool, error) {
+	return func(ctx context.Context, db gdb.DB, tableName string) (bool, error) {
+		return indexExists(ctx, db, tableName, indexName(db.GetConfig().Type, tableName, idx))
+	}
+}
+
+// statementSteps returns the steps executing the given statements.
+func statementSteps(statements ...string) []migrationStep {
+	steps := make([]migrationStep, 0, len(statements))
+	for _, sql := range statements {
+		steps = append(steps, migrationStep{sql: sql})
+	}
+	return steps
+}
+
+// legacyColumnLength is the length of the ptype and value columns in version 1 of the rule table.
+const legacyColumnLength = 100
+
+// migrations lists all schema versions of the rule table in ascending order.
+// Version 1 is the original layout with VARCHAR(100) columns and is never executed.
+var migrations = []migration{
+	{
+		version:     1,
+		description: "create casbin rule table",
+		plan: func(a *Adapter, dbType string, fields map[string]*gdb.TableField) []migrationStep {
+			return nil
+		},
+	},
+	{
+		version:     2,
+		description: "widen ptype and value columns",
+		plan: func(a *Adapter, dbType string, fields map[string]*gdb.TableField) []migrationStep {
+			var statements []string
+			for _, c := range a.ruleTableDef().columns {
+				if c.kind != kindString || c.name == ruleHashColumn.name {
+					continue
+				}
+				if sql := alterColumnSQL(dbType, a.dao.Table(), c); sql != "" {
+					statements = append(statements, sql)
+				}
+			}
+			return statementSteps(statements...)
+		},
+	},
+	{
+		version:     3,
+		description: "add unique rule hash",
+		plan: func(a *Adapter, dbType string, fields map[string]*gdb.TableField) []migrationStep {
+			steps := []migrationStep{{
+				sql:  addColumnSQL(dbType, a.dao.Table(), ruleHashColumn),
+				done: columnExists(ruleHashColumn.name),
+			}, {
+				sql: "-- compute rule_hash of existing rows and delete duplicated rules",
+				run: backfillRuleHash,
+			}}
+			if supportsIndexes(dbType) {
+				// The rules of a table with the tenant column are unique within a tenant.
+				index := ruleHashIndex
+				if tableField(fields, tenantColumn.name) != nil {
+					index = tenantRuleHashIndex
+				}
+				steps = append(steps, migrationStep{
+					sql:  createIndexSQL(dbType, a.dao.Table(), index),
+					done: indexCreated(index),
+				})
+			}
+			return steps
+		},
+	},
+}
+
+// backfillRuleHash computes the rule hash of the rows without one, reading and updating loadPageSize rows at once.
+// Rows duplicating an earlier row are deleted, as the unique index could not be created otherwise.
+// It only changes rows whose hash is missing or wrong, so it can be repeated after a failure.
+func backfillRuleHash(ctx context.Context, a *Adapter) error {
+	cols := a.dao.Columns()
+	fields := append([]string{cols.Id, cols.RuleHash}, a.ruleFields()...)
+	err := a.eachPage(func() *gdb.Model {
+		return a.dao.Ctx(ctx).Unscoped().Fields(fields)
+	}, a.loadPageSize, func(result gdb.Result) error {
+		hashes := make(map[int64]string, len(result))
+		for _, record := range result {
+			line := a.policyLine(record)
+			if hash := ruleHash(line[0], line[1:]); record[cols.RuleHash].String() != hash {
+				hashes[record[cols.Id].Int64()] = hash
+			}
+		}
+		return a.updateRuleHashes(ctx, hashes)
+	})
+	if err != nil {
+		return err
+	}
+	return a.deleteDuplicateRules(ctx)
+}
+
+// updateRuleHashes sets the rule hashes of the rows with the given ids in one statement.
+func (a *Adapter) updateRuleHashes(ctx context.Context, hashes map[int64]string) error {
+	if len(hashes) == 0 {
+		return nil
+	}
+	cols := a.dao.Columns()
+	var (
+		cases strings.Builder
+		args  = make([]interface{}, 0, len(hashes)*3)
+		ids   = make([]interface{}, 0, len(hashes))
+	)
+	for id, hash := range hashes {
+		cases.WriteString(" WHEN ? THEN ?")
+		args = append(args, id, hash)
+		ids = append(ids, id)
+	}
+	args = append(args, ids...)
+	sql := fmt.Sprintf(
+		"UPDATE %s SET %s = CASE %s%s END WHERE %s IN (%s)",
+		a.quote(a.dao.Table()), a.quote(cols.RuleHash), a.quote(cols.Id), cases.String(),
+		a.quote(cols.Id), strings.TrimSuffix(strings.Repeat("?,", len(ids)), ","),
+	)
+	_, err := a.dao.DB().Exec(ctx, sql, args...)
+	return err
+}
+
+// deleteDuplicateRules deletes all but the first row of every rule hash, loadPageSize hashes at once.
+// The rules of a table with the tenant column are unique within a tenant, so their duplicates are found per tenant.
+func (a *Adapter) deleteDuplicateRules(ctx context.Context) error {
+	cols := a.dao.Columns()
+	fields, err := a.dao.DB().TableFields(ctx, a.dao.Table())
+	if err != nil {
+		return err
+	}
+	group := []string{cols.RuleHash}
+	if tableField(fields, tenantColumn.name) != nil {
+		group = []string{tenantColumn.name, cols.RuleHash}
+	}
+	for {
+		result, err := a.dao.Ctx(ctx).Unscoped().
+			Fields(group).
+			FieldMin(cols.Id, "keep_id").
+			Group(group...).
+			Having("COUNT(1) > 1").
+			Limit(a.loadPageSize).
+			All()
+		if err != nil || len(result) == 0 {
+			return err
+		}
+		var (
+			conds = make([]string, 0, len(result))
+			args  = make([]interface{}, 0, len(result)*len(group))
+			keep  = make([]int64, 0, len(result))
+		)
+		for _, record := range result {
+			parts := make([]string, 0, len(group))
+			for _, column := range group {
+				parts = append(parts, a.quote(column)+" = ?")
+				args = append(args, record[column].String())
+			}
+			conds = append(conds, "("+strings.Join(parts, " AND ")+")")
+			keep = append(keep, record["keep_id"].Int64())
+		}
+		// A kept id of one group never matches a row of another group, so one statement deletes the duplicates of all.
+		_, err = a.dao.Ctx(ctx).Unscoped().
+			Where("("+strings.Join(conds, " OR ")+")", args...).
+			WhereNotIn(cols.Id, keep).
+			Delete()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// latestSchemaVersion returns the version of the rule table created from scratch.
+func latestSchemaVersion() int {
+	return migrations[len(migrations)-1].version
+}
+
+// migrationTableDef returns the definition of the table recording the schema versions.
+func migrationTableDef(tableName string) tableDef {
+	return tableDef{
+		name: tableName,
+		columns: []columnDef{
+			{name: "id", kind: kindID},
+			{name: "table_name", kind: kindString, size: 191},
+			{name: "version", kind: kindInt},
+			{name: "description", kind: kindString, size: 255},
+			{name: "applied_at", kind: kindTime},
+		},
+		indexes: []indexDef{
+			{name: "uk_table_version", columns: []string{"table_name", "version"}, unique: true},
+		},
+		comment: "Casbin schema migrations",
+	}
+}
+
+// SchemaVersion returns the schema version of the rule table.
+// It returns 0 if the table does not exist. The version of a table without a recorded version,
+// e.g. created from creat.sql or before migrations were recorded, is detected from its columns and indexes.
+// With WithTenantTables the rule table of the tenant of the context is checked.
+func (a *Adapter) SchemaVersion(ctx context.Context) (int, error) {
+	a, err := a.withTenantTable(ctx)
+	if err != nil {
+		return 0, err
+	}
+	version, _, err := a.schemaVersion(ctx)
+	return version, err
+}
+
+// schemaVersion returns the schema version of the rule table and whether it is recorded.
+func (a *Adapter) schemaVersion(ctx context.Context) (int, bool, error) {
+	db := a.dao.DB()
+	exists, err := tableExists(ctx, db, a.migrationTable)
+	if err != nil {
+		return 0, false, err
+	}
+	if exists {
+		value, err := db.Model(a.migrationTable).Ctx(ctx).
+			Where("table_name", a.dao.Table()).
+			Max("version")
+		if err != nil {
+			return 0, false, err
+		}
+		if value > 0 {
+			return int(value), true, nil
+		}
+	}
+	exists, err = tableExists(ctx, db, a.dao.Table())
+	if err != nil || !exists {
+		return 0, false, err
+	}
+	version, err := a.detectSchemaVersion(ctx, db)
+	return version, false, err
+}
+
+// detectSchemaVersion returns the version of the layout of the rule table:
+// 3 with the rule hash and its unique index, 2 with the ptype and value columns widened beyond 100 characters, 1 otherwise.
+func (a *Adapter) detectSchemaVersion(ctx context.Context, db gdb.DB) (int, error) {
+	dbType := db.GetConfig().Type
+	table := a.dao.Table()
+	// Read the columns from the database rather than from the gdb cache.
+	if err := db.GetCore().ClearTableFields(ctx, table); err != nil {
+		return 0, err
+	}
+	fields, err := db.TableFields(ctx, table)
+	if err != nil {
+		return 0, err
+	}
+	for _, c := range a.ruleTableDef().columns {
+		if c.kind != kindString || c.name == ruleHashColumn.name {
+			continue
+		}
+		if field := tableField(fields, c.name); field != nil {
+			if size := fieldSize(field.Type); size > 0 && size <= legacyColumnLength {
+				return 1, nil
+			}
+		}
+	}
+	if tableField(fields, ruleHashColumn.name) == nil {
+		return 2, nil
+	}
+	if supportsIndexes(dbType) {
+		exists, err := indexExists(ctx, db, table, indexName(dbType, table, ruleHashIndex))
+		if err == nil && !exists {
+			exists, err = indexExists(ctx, db, table, indexName(dbType, table, tenantRuleHashIndex))
+		}
+		if err != nil || !exists {
+			return 2, err
+		}
+	}
+	return 3, nil
+}
+
+// recordDetectedVersion records the detected schema version of an existing rule table without a recorded version.
+func (a *Adapter) recordDetectedVersion(ctx context.Context) error {
+	version, recorded, err := a.schemaVersion(ctx)
+	if err != nil || recorded || version == 0 {
+		return err
+	}
+	if _, err = createTableIfNotExists(ctx, a.dao.DB(), migrationTableDef(a.migrationTable)); err != nil {
+		return err
+	}
+	return a.recordMigration(ctx, version, "detect existing table")
+}
+
+// checkSchema returns an error matching ErrSchemaOutdated if the rule table exists but lacks the schema version,
+// columns or indexes used by the adapter, e.g. a table created with VARCHAR(100) columns and no rule hash,
+// or if an auxiliary table lacks a column, e.g. the tenant column.
+// Nothing is checked with tenant tables, they are created by the adapter and upgraded with the auxiliary tables by MigrateTenants.
+func (a *Adapter) checkSchema(ctx context.Context) error {
+	db := a.dao.DB()
+	dbType := db.GetConfig().Type
+	if a.tenantTable != "" || !isSupportedDBType(dbType) {
+		return nil
+	}
+	for _, def := range a.auxTableDefs() {
+		exists, err := tableExists(ctx, db, def.name)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			continue
+		}
+		statements, err := auxColumnStatements(ctx, db, dbType, def)
+		if err != nil {
+			return err
+		}
+		if len(statements) > 0 {
+			return fmt.Errorf("%w: table %s does not match the options of the adapter, Migrate executes %q", ErrSchemaOutdated, def.name, statements)
+		}
+	}
+	version, err := a.SchemaVersion(ctx)
+	if err != nil || version == 0 {
+		return err
+	}
+	if version < latestSchemaVersion() {
+		return fmt.Errorf("%w: table %s has schema version %d, the latest is %d", ErrSchemaOutdated, a.dao.Table(), version, latestSchemaVersion())
+	}
+	statements, err := a.columnStatements(ctx, db, dbType)
+	if err != nil {
+		return err
+	}
+	if len(statements) > 0 {
+		return fmt.Errorf("%w: table %s does not match the options of the adapter, Migrate executes %q", ErrSchemaOutdated, a.dao.Table(), statements)
+	}
+	return nil
+}
+
+// Migrate upgrades the rule table to the latest schema version.
+// A missing table is created with the latest layout, so are the missing tables of enabled features like the change log.
+// Every migration is recorded once it succeeds, and the steps of a failed run that are already in place are skipped,
+// so a failed run can be resumed.
+// With WithTenantTables the rule table of the tenant of the context is upgraded, see MigrateTenants.
+func (a *Adapter) Migrate(ctx context.Context) error {
+	_, err := a.migrate(ctx, false)
+	return err
+}
+
+// MigrateDryRun returns the statements Migrate would execute without changing the database.
+func (a *Adapter) MigrateDryRun(ctx context.Context) ([]string, error) {
+	return a.migrate(ctx, true)
+}
+
+// migrate plans the pending migrations and executes them unless dryRun is set.
+func (a *Adapter) migrate(ctx context.Context, dryRun bool) ([]string, error) {
+	a, err := a.withTenantTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+	db := a.dao.DB()
+	dbType := db.GetConfig().Type
+	if !isSupportedDBType(dbType) {
+		return nil, errors.New("invalid db type")
+	}
+
+	var planned []string
+	exists, err := tableExists(ctx, db, a.migrationTable)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		def := migrationTableDef(a.migrationTable)
+		if dryRun {
+			planned = append(planned, tableStatements(dbType, def)...)
+		} else if _, err = createTableIfNotExists(ctx, db, def); err != nil {
+			return nil, err
+		}
+	}
+
+	// The auxiliary tables are not versioned, they are created with the latest layout when missing
+	// and get the columns of the enabled features, like the tenant column, when they exist.
+	for _, def := range a.auxTableDefs() {
+		if exists, err = tableExists(ctx, db, def.name); err != nil {
+			return nil, err
+		}
+		if exists {
+			statements, err := auxColumnStatements(ctx, db, dbType, def)
+			if err != nil {
+				return nil, err
+			}
+			planned = append(planned, statements...)
+			if dryRun || len(statements) == 0 {
+				continue
+			}
+			for _, sql := range statements {
+				if _, err = db.Exec(ctx, sql); err != nil {
+					return nil, err
+				}
+			}
+			if err = db.GetCore().ClearTableFields(ctx, def.name); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if dryRun {
+			planned = append(planned, tableStatements(dbType, def)...)
+		} else if _, err = createTableIfNotExists(ctx, db, def); err != nil {
+			return nil, err
+		}
+	}
+
+	version, recorded, err := a.schemaVersion(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if version == 0 {
+		statements := tableStatements(dbType, a.ruleTableDef())
+		planned = append(planned, statements...)
+		if !dryRun {
+			if _, err = createTableIfNotExists(ctx, db, a.ruleTableDef()); err != nil {
+				return nil, err
+			}
+			if err = a.recordMigration(ctx, latestSchemaVersion(), "create casbin rule table"); err != nil {
+				return nil, err
+			}
+		}
+		return planned, nil
+	}
+
+	// The version of a table without a recorded version is detected from its layout, record it
+	// so the migrations are not planned from the layout again.
+	if !recorded && !dryRun {
+		if err = a.recordMigration(ctx, version, "detect existing table"); err != nil {
+			return nil, err
+		}
+	}
+
+	// Read the columns from the database rather than from the gdb cache.
+	if err = db.GetCore().ClearTableFields(ctx, a.dao.Table()); err != nil {
+		return nil, err
+	}
+	fields, err := db.TableFields(ctx, a.dao.Table())
+	if err != nil {
+		return nil, err
+	}
+
+	for _, m := range migrations {
+		if m.version <= version {
+			continue
+		}
+		for _, step := range m.plan(a, dbType, fields) {
+			// Skip the changes made by a failed run, so the migration can be resumed.
+			if step.done != nil {
+				done, err := step.done(ctx, db, a.dao.Table())
+				if err != nil {
+					return nil, err
+				}
+				if done {
+					continue
+				}
+			}
+			planned = append(planned, step.sql)
+			if dryRun {
+				continue
+			}
+			if step.run != nil {
+				err = step.run(ctx, a.unscoped())
+			} else {
+				_, err = db.Exec(ctx, step.sql)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("migration %d (%s): %w", m.version, m.description, err)
+			}
+			// Later steps may depend on the columns changed by this step.
+			if err = db.GetCore().ClearTableFields(ctx, a.dao.Table()); err != nil {
+				return nil, err
+			}
+		}
+		if dryRun {
+			continue
+		}
+		if err = a.recordMigration(ctx, m.version, m.description); err != nil {
+			return nil, err
+		}
+	}
+
+	// Bring the columns in line with the configured value columns and column length.
+	statements, err := a.columnStatements(ctx, db, dbType)
+	if err != nil {
+		return nil, err
+	}
+	planned = append(planned, statements...)
+	if !dryRun {
+		for _, sql := range statements {
+			if _, err = db.Exec(ctx, sql); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if !dryRun {
+		// The cached table fields of gdb are outdated after a migration.
+		if err = db.GetCore().ClearTableFields(ctx, a.dao.Table()); err != nil {
+			return nil, err
+		}
+	}
+	return planned, nil
+}
+
+// columnStatements returns the statements adding the missing value, validity, soft delete and tenant columns
+// with their indexes and widening the columns shorter than the column length.
+// They depend on the adapter settings rather than on the schema version, so they are planned on every run.
+func (a *Adapter) columnStatements(ctx context.Context, db gdb.DB, dbType string) ([]string, error) {
+	fields, err := db.TableFields(ctx, a.dao.Table())
+	if err != nil {
+		return nil, err
+	}
+	def := a.ruleTableDef()
+	var statements []string
+	for _, c := range def.columns {
+		if c.kind != kindString && c.kind != kindTime {
+			continue
+		}
+		field := tableField(fields, c.name)
+		if field == nil {
+			statements = append(statements, addColumnSQL(dbType, def.name, c))
+			if !supportsIndexes(dbType) {
+				continue
+			}
+			for _, idx := range def.indexes {
+				if len(idx.columns) == 1 && idx.columns[0] == c.name {
+					statements = append(statements, createIndexSQL(dbType, def.name, idx))
+				}
+			}
+			continue
+		}
+		if size := fieldSize(field.Type); size > 0 && size < c.size {
+			if sql := alterColumnSQL(dbType, def.name, c); sql != "" {
+				statements = append(statements, sql)
+			}
+		}
+	}
+
+	if a.tenantMode && supportsIndexes(dbType) {
+		// Rules are unique within a tenant, so the unique index on the rule hash alone is replaced.
+		exists, err := indexExists(ctx, db, def.name, indexName(dbType, def.name, ruleHashIndex))
+		if err != nil {
+			return nil, err
+		}
+		if exists {
+			statements = append(statements, dropIndexSQL(dbType, def.name, ruleHashIndex))
+		}
+		exists, err = indexExists(ctx, db, def.name, indexName(dbType, def.name, tenantRuleHashIndex))
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			statements = append(statements, createIndexSQL(dbType, def.name, tenantRuleHashIndex))
+		}
+	}
+	return statements, nil
+}
+
+// auxColumnStatements returns the statements adding the columns of the auxiliary table missing from the existing one,
+// with their indexes.
+func auxColumnStatements(ctx context.Context, db gdb.DB, dbType string, def tableDef) ([]string, error) {
+	fields, err := db.TableFields(ctx, def.name)
+	if err != nil {
+		return nil, err
+	}
+	var statements []string
+	for _, c := range def.columns {
+		if tableField(fields, c.name) != nil {
+			continue
+		}
+		statements = append(statements, addColumnSQL(dbType, def.name, c))
+		if !supportsIndexes(dbType) {
+			continue
+		}
+		for _, idx := range def.indexes {
+			if len(idx.columns) == 1 && idx.columns[0] == c.name {
+				statements = append(statements, createIndexSQL(dbType, def.name, idx))
+			}
+		}
+	}
+	return statements, nil
+}
+
+// fieldSizeRegex matches the size of a column type like "varchar(100)".
+var fieldSizeRegex = regexp.MustCompile(`\((\d+)\)`)
+
+// fieldSize returns the size of a column type, or 0 if the type has no size.
+func fieldSize(fieldType string) int {
+	match := fieldSizeRegex.FindStringSubmatch(fieldType)
+	if match == nil {
+		return 0
+	}
+	size, _ := strconv.Atoi(match[1])
+	return size
+}
+
+// recordMigration records that the rule table has been upgraded to version.
+func (a *Adapter) recordMigration(ctx context.Context, version int, description string) error {
+	_, err := a.dao.DB().Model(a.migrationTable).Ctx(ctx).Data(g.Map{
+		"table_name":  a.dao.Table(),
+		"version":     version,
+		"description": description,
+		"applied_at":  time.Now(),
+	}).InsertIgnore()
+	return err
+}
+
+// recordCreatedTable records the latest schema version for a rule table created by the adapter.
+func (a *Adapter) recordCreatedTable(ctx context.Context) error {
+	if _, err := createTableIfNotExists(ctx, a.dao.DB(), migrationTableDef(a.migrationTable)); err != nil {
+		return err
+	}
+	return a.recordMigration(ctx, latestSchemaVersion(), "create casbin rule table")
+}
@@ -0,0 +1,181 @@
+package gfadapter
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/gogf/gf/v2/database/gdb"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/yclw/gf-casbin-adapter/dao"
+)
+
+func TestMigrationPlans(t *testing.T) {
+	a := &Adapter{
+		dao:          dao.NewCasbinRuleDaoWithName("casbin_rule"),
+		valueColumns: []string{"v0", "v1", "v2", "v3", "v4", "v5"},
+		columnLength: DefaultColumnLength,
+	}
+
+	for i, m := range migrations {
+		assert.Equal(t, i+1, m.version)
+	}
+	assert.Equal(t, migrations[len(migrations)-1].version, latestSchemaVersion())
+
+	widen := migrations[1].plan(a, "mysql", nil)
+	assert.Len(t, widen, 7)
+	assert.Equal(t, "ALTER TABLE casbin_rule MODIFY COLUMN ptype VARCHAR(255) DEFAULT '' NOT NULL", widen[0].sql)
+	assert.Equal(t, "ALTER TABLE casbin_rule ALTER COLUMN v0 TYPE VARCHAR(255)", migrations[1].plan(a, "pgsql", nil)[1].sql)
+	assert.Empty(t, migrations[1].plan(a, "sqlite", nil))
+
+	hash := migrations[2].plan(a, "pgsql", nil)
+	assert.Len(t, hash, 3)
+	assert.Equal(t, "ALTER TABLE casbin_rule ADD COLUMN rule_hash VARCHAR(64) DEFAULT '' NOT NULL", hash[0].sql)
+	assert.NotNil(t, hash[0].done)
+	assert.NotNil(t, hash[1].run)
+	assert.NotNil(t, hash[2].done)
+	assert.Equal(t, "CREATE UNIQUE INDEX casbin_rule_uk_rule_hash ON casbin_rule (rule_hash)", hash[2].sql)
+	assert.Len(t, migrations[2].plan(a, "clickhouse", nil), 2)
+
+	// The rule hash of a table with the tenant column is unique within a tenant.
+	hash = migrations[2].plan(a, "mysql", map[string]*gdb.TableField{"tenant_id": {Name: "tenant_id"}})
+	assert.Equal(t, createIndexSQL("mysql", "casbin_rule", tenantRuleHashIndex), hash[2].sql)
+}
+
+func TestMigrate(t *testing.T) {
+	a, err := NewAdapterWithOptions(WithTableName("test_casbin_rule_migrate"), WithSchemaCheck(false))
+	assert.Nil(t, err)
+	ctx := context.Background()
+
+	_, err = a.dao.DB().Exec(ctx, "DROP TABLE IF EXISTS "+a.dao.Table())
+	assert.Nil(t, err)
+	_, err = a.dao.DB().Model(a.migrationTable).Ctx(ctx).Where("table_name", a.dao.Table()).Delete()
+	assert.Nil(t, err)
+
+	// Simulate a table created before migrations were recorded.
+	_, err = a.dao.DB().Exec(ctx, "CREATE TABLE "+a.dao.Table()+" (id BIGINT AUTO_INCREMENT PRIMARY KEY, ptype VARCHAR(100) DEFAULT '' NOT NULL, v0 VARCHAR(100) DEFAULT '' NOT NULL, v1 VARCHAR(100) DEFAULT '' NOT NULL, v2 VARCHAR(100) DEFAULT '' NOT NULL, v3 VARCHAR(100) DEFAULT '' NOT NULL, v4 VARCHAR(100) DEFAULT '' NOT NULL, v5 VARCHAR(100) DEFAULT '' NOT NULL)")
+	assert.Nil(t, err)
+
+	version, err := a.SchemaVersion(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, version)
+
+	// The old table is not used before it is migrated, except by the constructors that never checked it.
+	_, err = NewAdapterWithOptions(WithTableName("test_casbin_rule_migrate"))
+	assert.True(t, errors.Is(err, ErrSchemaOutdated))
+	_, err = NewAdapterWithName("test_casbin_rule_migrate", DisabledFiltered)
+	assert.Nil(t, err)
+
+	planned, err := a.MigrateDryRun(ctx)
+	assert.Nil(t, err)
+	assert.NotEmpty(t, planned)
+
+	assert.Nil(t, a.Migrate(ctx))
+	version, err = a.SchemaVersion(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, latestSchemaVersion(), version)
+
+	planned, err = a.MigrateDryRun(ctx)
+	assert.Nil(t, err)
+	assert.Empty(t, planned)
+
+	_, err = NewAdapterWithOptions(WithTableName("test_casbin_rule_migrate"))
+	assert.Nil(t, err)
+	_, err = NewAdapterWithOptions(WithTableName("test_casbin_rule_migrate"), WithSoftDelete(true))
+	assert.True(t, errors.Is(err, ErrSchemaOutdated))
+
+	initPolicy(t, a)
+}
+
+func TestSchemaVersionDetected(t *testing.T) {
+	a, err := NewAdapterWithOptions(WithTableName("test_casbin_rule_detected"), WithSchemaCheck(false))
+	assert.Nil(t, err)
+	ctx := context.Background()
+	db := a.dao.DB()
+
+	_, err = db.Exec(ctx, "DROP TABLE IF EXISTS "+a.dao.Table())
+	assert.Nil(t, err)
+	_, err = db.Model(a.migrationTable).Ctx(ctx).Where("table_name", a.dao.Table()).Delete()
+	assert.Nil(t, err)
+
+	// A table created from the script has the latest layout without a recorded version.
+	_, err = db.Exec(ctx, GetCreateTableSQLByTemplate("mysql", a.dao.Table()))
+	assert.Nil(t, err)
+	version, err := a.SchemaVersion(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, latestSchemaVersion(), version)
+	_, err = NewAdapterWithOptions(WithTableName("test_casbin_rule_detected"))
+	assert.Nil(t, err)
+
+	planned, err := a.MigrateDryRun(ctx)
+	assert.Nil(t, err)
+	assert.Empty(t, planned)
+	assert.Nil(t, a.Migrate(ctx))
+	recorded, err := db.Model(a.migrationTable).Ctx(ctx).Where("table_name", a.dao.Table()).Max("version")
+	assert.Nil(t, err)
+	assert.Equal(t, float64(latestSchemaVersion()), recorded)
+}
+
+func TestMigrateResume(t *testing.T) {
+	a, err := NewAdapterWithOptions(WithTableName("test_casbin_rule_migrate_resume"), WithLoadPageSize(2), WithSchemaCheck(false))
+	assert.Nil(t, err)
+	ctx := context.Background()
+	db := a.dao.DB()
+
+	_, err = db.Exec(ctx, "DROP TABLE IF EXISTS "+a.dao.Table())
+	assert.Nil(t, err)
+	_, err = db.Model(a.migrationTable).Ctx(ctx).Where("table_name", a.dao.Table()).Delete()
+	assert.Nil(t, err)
+
+	// Simulate a run that failed after adding the rule hash column, with duplicated rules.
+	_, err = db.Exec(ctx, "CREATE TABLE "+a.dao.Table()+" (id BIGINT AUTO_INCREMENT PRIMARY KEY, ptype VARCHAR(100) DEFAULT '' NOT NULL, v0 VARCHAR(100) DEFAULT '' NOT NULL, v1 VARCHAR(100) DEFAULT '' NOT NULL, v2 VARCHAR(100) DEFAULT '' NOT NULL, v3 VARCHAR(100) DEFAULT '' NOT NULL, v4 VARCHAR(100) DEFAULT '' NOT NULL, v5 VARCHAR(100) DEFAULT '' NOT NULL, rule_hash VARCHAR(64) DEFAULT '' NOT NULL)")
+	assert.Nil(t, err)
+	_, err = db.Exec(ctx, "INSERT INTO "+a.dao.Table()+" (ptype, v0, v1, v2) VALUES ('p', 'alice', 'data1', 'read'), ('p', 'bob', 'data2', 'write'), ('p', 'alice', 'data1', 'read')")
+	assert.Nil(t, err)
+
+	planned, err := a.MigrateDryRun(ctx)
+	assert.Nil(t, err)
+	assert.NotContains(t, planned, addColumnSQL("mysql", a.dao.Table(), ruleHashColumn))
+
+	assert.Nil(t, a.Migrate(ctx))
+	version, err := a.SchemaVersion(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, latestSchemaVersion(), version)
+
+	result, err := a.dao.Ctx(ctx).Order("id").All()
+	assert.Nil(t, err)
+	assert.Len(t, result, 2)
+	for _, record := range result {
+		line := a.policyLine(record)
+		assert.Equal(t, ruleHash(line[0], line[1:]), record["rule_hash"].String())
+	}
+}
+
+func TestMigrateTenantRules(t *testing.T) {
+	a, err := NewAdapterWithOptions(WithTableName("test_casbin_rule_migrate_tenants"), WithSchemaCheck(false))
+	assert.Nil(t, err)
+	ctx := context.Background()
+	db := a.dao.DB()
+
+	_, err = db.Exec(ctx, "DROP TABLE IF EXISTS "+a.dao.Table())
+	assert.Nil(t, err)
+	_, err = db.Model(a.migrationTable).Ctx(ctx).Where("table_name", a.dao.Table()).Delete()
+	assert.Nil(t, err)
+
+	// The same rule of two tenants is not a duplicate.
+	_, err = db.Exec(ctx, "CREATE TABLE "+a.dao.Table()+" (id BIGINT AUTO_INCREMENT PRIMARY KEY, ptype VARCHAR(100) DEFAULT '' NOT NULL, v0 VARCHAR(100) DEFAULT '' NOT NULL, v1 VARCHAR(100) DEFAULT '' NOT NULL, v2 VARCHAR(100) DEFAULT '' NOT NULL, v3 VARCHAR(100) DEFAULT '' NOT NULL, v4 VARCHAR(100) DEFAULT '' NOT NULL, v5 VARCHAR(100) DEFAULT '' NOT NULL, tenant_id VARCHAR(64) DEFAULT '' NOT NULL)")
+	assert.Nil(t, err)
+	_, err = db.Exec(ctx, "INSERT INTO "+a.dao.Table()+" (ptype, v0, v1, v2, tenant_id) VALUES ('p', 'alice', 'data1', 'read', 't1'), ('p', 'alice', 'data1', 'read', 't2'), ('p', 'alice', 'data1', 'read', 't1')")
+	assert.Nil(t, err)
+
+	assert.Nil(t, a.Migrate(ctx))
+	result, err := a.dao.Ctx(ctx).Fields(a.dao.Columns().Id, tenantColumn.name).Order("id").All()
+	assert.Nil(t, err)
+	assert.Len(t, result, 2)
+	assert.Equal(t, "t1", result[0][tenantColumn.name].String())
+	assert.Equal(t, "t2", result[1][tenantColumn.name].String())
+	exists, err := indexExists(ctx, db, a.dao.Table(), tenantRuleHashIndex.name)
+	assert.Nil(t, err)
+	assert.True(t, exists)
+}
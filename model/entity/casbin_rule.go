@@ -6,12 +6,13 @@ package entity
 
 // CasbinRule is the golang structure for table casbin_rule.
 type CasbinRule struct {
-	Id    int64  `json:"id"    orm:"id"    ` //
-	Ptype string `json:"ptype" orm:"ptype" ` //
-	V0    string `json:"v0"    orm:"v0"    ` //
-	V1    string `json:"v1"    orm:"v1"    ` //
-	V2    string `json:"v2"    orm:"v2"    ` //
-	V3    string `json:"v3"    orm:"v3"    ` //
-	V4    string `json:"v4"    orm:"v4"    ` //
-	V5    string `json:"v5"    orm:"v5"    ` //
+	Id       int64  `json:"id"       orm:"id"        ` //
+	Ptype    string `json:"ptype"    orm:"ptype"     ` //
+	V0       string `json:"v0"       orm:"v0"        ` //
+	V1       string `json:"v1"       orm:"v1"        ` //
+	V2       string `json:"v2"       orm:"v2"        ` //
+	V3       string `json:"v3"       orm:"v3"        ` //
+	V4       string `json:"v4"       orm:"v4"        ` //
+	V5       string `json:"v5"       orm:"v5"        ` //
+	RuleHash string `json:"ruleHash" orm:"rule_hash" ` //
 }
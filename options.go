@@ -0,0 +1,248 @@
+package gfadapter
+
+import (
+	"time"
+
+	"github.com/gogf/gf/v2/database/gdb"
+)
+
+// DefaultTableName is the table name used when no table name is given.
+const DefaultTableName = "casbin_rule"
+
+// DefaultValueColumns is the number of value columns, v0 to v5, used when no number is given.
+const DefaultValueColumns = 6
+
+// DefaultColumnLength is the length of the ptype and value columns used when no length is given.
+const DefaultColumnLength = 255
+
+// DefaultLoadPageSize is the number of rows read at once when loading rules, used when no page size is given.
+const DefaultLoadPageSize = 1000
+
+// SaveMode selects how SavePolicy replaces the stored rules.
+type SaveMode int
+
+const (
+	// SaveModeDiff deletes the stored rules missing from the model and inserts the new ones in one transaction.
+	// The ids of the unchanged rules are kept.
+	SaveModeDiff SaveMode = iota
+	// SaveModeTruncate empties the table and inserts all rules again, which resets the ids.
+	// TRUNCATE commits implicitly on MySQL, so the table is left empty if inserting the rules fails.
+	SaveModeTruncate
+)
+
+// Option configures an Adapter created by NewAdapterWithOptions.
+type Option func(*options)
+
+// options holds the settings collected from Option values.
+type options struct {
+	group              string
+	db                 gdb.DB
+	tableName          string
+	migrationTable     string
+	changeLogTable     string
+	auditTable         string
+	snapshotTable      string
+	validity           bool
+	softDelete         bool
+	tenantMode         bool
+	tenantID           string
+	tenantTables       bool
+	tenantTablePattern string
+	prefix             *string
+	isFiltered         UserFiltered
+	autoCreate         bool
+	schemaCheck        bool
+	valueColumns       int
+	columnLength       int
+	saveMode           SaveMode
+	loadPageSize       int
+	replicaLag         time.Duration
+}
+
+// WithGroup sets the database configuration group, "default" is used if not set.
+// It is ignored if WithDB is given.
+func WithGroup(group string) Option {
+	return func(o *options) {
+		o.group = group
+	}
+}
+
+// WithDB sets the database object used by the adapter.
+func WithDB(db gdb.DB) Option {
+	return func(o *options) {
+		o.db = db
+	}
+}
+
+// WithTableName sets the table name, "casbin_rule" is used if not set.
+func WithTableName(tableName string) Option {
+	return func(o *options) {
+		o.tableName = tableName
+	}
+}
+
+// WithMigrationTableName sets the table recording the schema versions, "casbin_schema_migrations" is used if not set.
+// The table can be shared by several adapters.
+func WithMigrationTableName(tableName string) Option {
+	return func(o *options) {
+		o.migrationTable = tableName
+	}
+}
+
+// WithTablePrefix sets the prefix added to the table name if it is not present yet.
+// By default the prefix configured for the database group is used,
+// an empty prefix disables prefix handling.
+func WithTablePrefix(prefix string) Option {
+	return func(o *options) {
+		o.prefix = &prefix
+	}
+}
+
+// WithFiltered sets whether the adapter is created in filtered mode.
+func WithFiltered(isFiltered UserFiltered) Option {
+	return func(o *options) {
+		o.isFiltered = isFiltered
+	}
+}
+
+// WithAutoCreateTable sets whether the table is created when the adapter is created.
+// A table created this way is recorded with the latest schema version, an existing table with the version detected from its layout.
+// Use Adapter.Migrate to upgrade existing tables.
+func WithAutoCreateTable(enabled bool) Option {
+	return func(o *options) {
+		o.autoCreate = enabled
+	}
+}
+
+// WithSchemaCheck sets whether creating the adapter fails with ErrSchemaOutdated if the rule table needs Migrate,
+// enabled by default for NewAdapterWithOptions and disabled for the other constructors.
+// Disable it to create an adapter for running Migrate or MigrateDryRun.
+func WithSchemaCheck(enabled bool) Option {
+	return func(o *options) {
+		o.schemaCheck = enabled
+	}
+}
+
+// WithValueColumns sets the number of value columns v0..vn, at least 6.
+// Rules with more values than value columns are rejected.
+func WithValueColumns(n int) Option {
+	return func(o *options) {
+		o.valueColumns = n
+	}
+}
+
+// WithColumnLength sets the length of the ptype and value columns.
+// Values longer than the column length are rejected.
+func WithColumnLength(length int) Option {
+	return func(o *options) {
+		o.columnLength = length
+	}
+}
+
+// WithChangeLog records every change of the rules in a change log table, in the same transaction as the change.
+// The change log is read by the Watcher to notify other instances, "casbin_rule_change" is used if tableName is empty.
+func WithChangeLog(tableName string) Option {
+	return func(o *options) {
+		if tableName == "" {
+			tableName = DefaultChangeLogTableName
+		}
+		o.changeLogTable = tableName
+	}
+}
+
+// WithAuditLog records every changed rule in an audit table, in the same transaction as the change,
+// with the actor and reason set on the context by WithAuditActor and WithAuditReason.
+// The entries are read by Adapter.AuditLog, "casbin_rule_audit" is used if tableName is empty.
+func WithAuditLog(tableName string) Option {
+	return func(o *options) {
+		if tableName == "" {
+			tableName = DefaultAuditTableName
+		}
+		o.auditTable = tableName
+	}
+}
+
+// WithSnapshots enables Adapter.Snapshot and the related functions,
+// storing the snapshots in tableName and their rules in tableName followed by "_rule".
+// "casbin_rule_snapshot" is used if tableName is empty.
+func WithSnapshots(tableName string) Option {
+	return func(o *options) {
+		if tableName == "" {
+			tableName = DefaultSnapshotTableName
+		}
+		o.snapshotTable = tableName
+	}
+}
+
+// WithValidityWindows adds the valid_from and valid_until columns to the rule table,
+// so rules added by AddPolicyWithExpiryCtx are only loaded within their validity window.
+// An existing rule table gets the columns from Migrate.
+// SavePolicy keeps the rules outside their window, except in SaveModeTruncate.
+func WithValidityWindows(enabled bool) Option {
+	return func(o *options) {
+		o.validity = enabled
+	}
+}
+
+// WithSoftDelete adds the deleted_at column to the rule table, so removed rules are only marked as deleted.
+// gdb skips the marked rows, RestorePolicy undeletes them and PurgeDeleted deletes them permanently.
+// An existing rule table gets the column from Migrate. Soft delete stays in effect while the table has the column,
+// SavePolicy in SaveModeTruncate deletes the rules permanently.
+func WithSoftDelete(enabled bool) Option {
+	return func(o *options) {
+		o.softDelete = enabled
+	}
+}
+
+// WithTenantScope adds the tenant_id column to the rule table and scopes all rule operations to one tenant,
+// so the rules of other tenants are never loaded, changed or deleted, SavePolicy included.
+// A non-empty tenant fixes the tenant of the adapter, otherwise it is taken from the context set by WithTenant
+// and the operations fail without one. An existing rule table gets the column from Migrate.
+// The change log, audit and snapshot tables get the column too, so LoadPolicySince, AuditLog and the snapshots
+// only see the rows of the tenant, while CompactChangeLog compacts the changes of all tenants.
+func WithTenantScope(tenant string) Option {
+	return func(o *options) {
+		o.tenantMode = true
+		o.tenantID = tenant
+	}
+}
+
+// WithTenantTables stores the rules of every tenant in its own table, named by pattern with %s replaced by the tenant.
+// The table name followed by "_%s" is used if pattern is empty, e.g. "casbin_rule_acme" for the tenant "acme".
+// The tenant is taken from the context set by WithTenant and the operations fail without one.
+// A tenant table is created on first use, Adapter.Tenants, DropTenant and MigrateTenants manage the existing ones.
+// The change log, audit and snapshot tables are shared by all tenants, their rows record the tenant like with WithTenantScope.
+func WithTenantTables(pattern string) Option {
+	return func(o *options) {
+		o.tenantTables = true
+		o.tenantTablePattern = pattern
+	}
+}
+
+// WithSaveMode sets how SavePolicy replaces the stored rules, SaveModeDiff is used if not set.
+func WithSaveMode(mode SaveMode) Option {
+	return func(o *options) {
+		o.saveMode = mode
+	}
+}
+
+// WithLoadPageSize sets the number of rows read at once when loading rules.
+// Only one page of rows is held in memory besides the model.
+func WithLoadPageSize(n int) Option {
+	return func(o *options) {
+		o.loadPageSize = n
+	}
+}
+
+// WithReplicaReads lets the loads read the replicas, configured as slave nodes of the database group,
+// while the changes and all other reads use the master.
+// Loads within lag after a change made by the adapter still read the master, so the adapter reads its own changes,
+// DefaultReplicaLag is used if lag is not positive. WithReadNode overrides the node of a call.
+func WithReplicaReads(lag time.Duration) Option {
+	return func(o *options) {
+		if lag <= 0 {
+			lag = DefaultReplicaLag
+		}
+		o.replicaLag = lag
+	}
+}
@@ -0,0 +1,65 @@
+package gfadapter
+
+import (
+	"context"
+	"time"
+
+	"github.com/gogf/gf/v2/database/gdb"
+)
+
+// DefaultReplicaLag is the time after a change during which loads still read the master, used when no lag is given.
+const DefaultReplicaLag = time.Second
+
+// ReadNode selects the database node read by the loads of the adapter, see WithReadNode.
+type ReadNode int
+
+const (
+	// ReadNodeAuto reads a replica if the adapter is created with WithReplicaReads
+	// and has not changed any rule within the replica lag, and the master otherwise.
+	ReadNodeAuto ReadNode = iota
+	// ReadNodeMaster reads the master, so the changes committed before are always seen.
+	ReadNodeMaster
+	// ReadNodeReplica reads a replica, also right after a change.
+	ReadNodeReplica
+)
+
+// readNodeContextKey is the context key of the ReadNode.
+type readNodeContextKey struct{}
+
+// WithReadNode returns a context whose loads read the given node, overriding the choice of the adapter.
+// Reads within a transaction always use the transaction.
+func WithReadNode(ctx context.Context, node ReadNode) context.Context {
+	return context.WithValue(ctx, readNodeContextKey{}, node)
+}
+
+// readNode returns the node set on ctx by WithReadNode.
+func readNode(ctx context.Context) ReadNode {
+	node, _ := ctx.Value(readNodeContextKey{}).(ReadNode)
+	return node
+}
+
+// pinMaster makes the reads of a model use the master unless they are routed to a replica by loadModel.
+func pinMaster(m *gdb.Model) *gdb.Model {
+	return m.Master()
+}
+
+// withLoadNode returns a context fixing the node read by a load, so all reads of the load use the same node.
+func (a *Adapter) withLoadNode(ctx context.Context) context.Context {
+	if readNode(ctx) != ReadNodeAuto {
+		return ctx
+	}
+	node := ReadNodeMaster
+	if a.replicaLag > 0 && time.Since(time.Unix(0, a.state.lastChange.Load())) >= a.replicaLag {
+		node = ReadNodeReplica
+	}
+	return WithReadNode(ctx, node)
+}
+
+// loadModel returns a model of the rule table for the reads of a load, reading a replica if ctx selects one.
+func (a *Adapter) loadModel(ctx context.Context) *gdb.Model {
+	m := a.dao.Ctx(ctx)
+	if readNode(ctx) == ReadNodeReplica {
+		return m.Slave()
+	}
+	return m
+}
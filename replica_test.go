@@ -0,0 +1,29 @@
+package gfadapter
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadNode(t *testing.T) {
+	ctx := context.Background()
+	a := &Adapter{state: &adapterState{}}
+	assert.Equal(t, ReadNodeMaster, readNode(a.withLoadNode(ctx)))
+
+	a.replicaLag = time.Minute
+	assert.Equal(t, ReadNodeReplica, readNode(a.withLoadNode(ctx)))
+
+	// The adapter reads its own changes from the master until the lag is over.
+	a.state.lastChange.Store(time.Now().UnixNano())
+	assert.Equal(t, ReadNodeMaster, readNode(a.withLoadNode(ctx)))
+	a.state.lastChange.Store(time.Now().Add(-2 * time.Minute).UnixNano())
+	assert.Equal(t, ReadNodeReplica, readNode(a.withLoadNode(ctx)))
+
+	// The node set on the context wins.
+	assert.Equal(t, ReadNodeMaster, readNode(a.withLoadNode(WithReadNode(ctx, ReadNodeMaster))))
+	a.replicaLag = 0
+	assert.Equal(t, ReadNodeReplica, readNode(a.withLoadNode(WithReadNode(ctx, ReadNodeReplica))))
+}
@@ -0,0 +1,261 @@
+package gfadapter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/casbin/casbin/v2/model"
+	"github.com/gogf/gf/v2/database/gdb"
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/util/gconv"
+)
+
+// DefaultSnapshotTableName is the name of the snapshot table used when WithSnapshots is given an empty name.
+// The rules of the snapshots are stored in the table of the same name followed by "_rule".
+const DefaultSnapshotTableName = "casbin_rule_snapshot"
+
+// errSnapshotsDisabled is returned by the snapshot functions of an adapter created without WithSnapshots.
+var errSnapshotsDisabled = errors.New("snapshots are not enabled, create the adapter with WithSnapshots")
+
+// SnapshotInfo describes a snapshot of the rules.
+type SnapshotInfo struct {
+	ID        int64
+	Label     string
+	Rules     int // Rules is the number of rules in the snapshot.
+	CreatedAt time.Time
+}
+
+// snapshotTableDef returns the definition of the table listing the snapshots.
+func snapshotTableDef(tableName string) tableDef {
+	return tableDef{
+		name: tableName,
+		columns: []columnDef{
+			{name: "id", kind: kindID},
+			{name: "label", kind: kindText},
+			{name: "rule_count", kind: kindInt},
+			{name: "created_at", kind: kindTime},
+		},
+		comment: "Casbin rule snapshots",
+	}
+}
+
+// snapshotRuleTableDef returns the definition of the table holding the rules of the snapshots.
+func snapshotRuleTableDef(tableName string, valueColumns int, columnLength int) tableDef {
+	t := tableDef{
+		name: tableName,
+		columns: []columnDef{
+			{name: "id", kind: kindID},
+			{name: "snapshot_id", kind: kindInt},
+			{name: "ptype", kind: kindString, size: columnLength},
+		},
+		indexes: []indexDef{
+			{name: "idx_snapshot_id", columns: []string{"snapshot_id"}},
+		},
+		comment: "Casbin rules of the snapshots",
+	}
+	for i := 0; i < valueColumns; i++ {
+		t.columns = append(t.columns, columnDef{name: fmt.Sprintf("v%d", i), kind: kindString, size: columnLength})
+	}
+	return t
+}
+
+// Snapshot copies all rules to a new snapshot with the given label and returns its ID.
+// The rules are read in one repeatable read transaction on the databases having this isolation level,
+// so all pages see the same rules and the snapshot is consistent with concurrent changes.
+// Rules outside their validity window are not part of the snapshot.
+func (a *Adapter) Snapshot(ctx context.Context, label string) (int64, error) {
+	if a.snapshotTable == "" {
+		return 0, errSnapshotsDisabled
+	}
+	a, err := a.forTenant(ctx)
+	if err != nil {
+		return 0, err
+	}
+	cols := a.dao.Columns()
+	opts := gdb.DefaultTxOptions()
+	if readOpts, ok := consistentReadOptions(a.dao.DB().GetConfig().Type); ok {
+		opts.Isolation = readOpts.Isolation
+	}
+	var id int64
+	err = a.dao.TransactionWithOptions(ctx, opts, func(ctx context.Context, tx gdb.TX) error {
+		var err error
+		id, err = a.logModel(ctx, a.snapshotTable).TX(tx).Data(g.Map{
+			"label":      label,
+			"created_at": time.Now(),
+		}).InsertAndGetId()
+		if err != nil {
+			return err
+		}
+		count := 0
+		now := time.Now()
+		query := func() *gdb.Model {
+			return a.whereValid(a.dao.Ctx(ctx).TX(tx), now).Fields(append([]string{cols.Id}, a.ruleFields()...))
+		}
+		err = a.eachPage(query, flushEvery, func(result gdb.Result) error {
+			rows := make([]g.Map, 0, len(result))
+			for _, record := range result {
+				row := g.Map{"snapshot_id": id}
+				for _, field := range a.ruleFields() {
+					row[field] = record[field].String()
+				}
+				rows = append(rows, row)
+			}
+			count += len(rows)
+			_, err := a.model(ctx, a.snapshotRuleTable()).TX(tx).Data(rows).Insert()
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		_, err = a.logModel(ctx, a.snapshotTable).TX(tx).Data(g.Map{"rule_count": count}).Where("id", id).Update()
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// ListSnapshots returns all snapshots, the latest first.
+func (a *Adapter) ListSnapshots(ctx context.Context) ([]SnapshotInfo, error) {
+	if a.snapshotTable == "" {
+		return nil, errSnapshotsDisabled
+	}
+	result, err := a.logModel(ctx, a.snapshotTable).OrderDesc("id").All()
+	if err != nil {
+		return nil, err
+	}
+	snapshots := make([]SnapshotInfo, 0, len(result))
+	for _, record := range result {
+		snapshots = append(snapshots, SnapshotInfo{
+			ID:        record["id"].Int64(),
+			Label:     record["label"].String(),
+			Rules:     record["rule_count"].Int(),
+			CreatedAt: record["created_at"].Time(),
+		})
+	}
+	return snapshots, nil
+}
+
+// RestoreSnapshot replaces the stored rules by the rules of the snapshot, in one transaction.
+// Like SavePolicy in SaveModeDiff, only the rules that differ are written and the change is recorded as a save.
+// The enforcers have to load the rules again afterwards.
+func (a *Adapter) RestoreSnapshot(ctx context.Context, id int64) error {
+	if a.snapshotTable == "" {
+		return errSnapshotsDisabled
+	}
+	a, err := a.forTenant(ctx)
+	if err != nil {
+		return err
+	}
+	return a.dao.Transaction(ctx, func(ctx context.Context, tx gdb.TX) error {
+		if err := a.checkSnapshot(ctx, tx, id); err != nil {
+			return err
+		}
+		var lines []g.Map
+		seen := make(map[string]bool)
+		query := a.snapshotQuery(ctx, tx, id)
+		fields := append([]string{a.dao.Columns().Id}, a.ruleFields()...)
+		err := a.eachPage(func() *gdb.Model {
+			return query().Fields(fields)
+		}, flushEvery, func(result gdb.Result) error {
+			for _, record := range result {
+				policyLine := a.policyLine(record)
+				if len(policyLine) == 0 {
+					continue
+				}
+				line, err := a.savePolicyLine(policyLine[0], policyLine[1:])
+				if err != nil {
+					return err
+				}
+				hash := gconv.String(line[a.dao.Columns().RuleHash])
+				if seen[hash] {
+					continue
+				}
+				seen[hash] = true
+				lines = append(lines, line)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		removed, added, err := a.syncPolicyLines(ctx, tx, lines)
+		if err != nil {
+			return err
+		}
+		return a.recordChange(ctx, tx, change{op: ChangeSave, diff: true, removedLines: removed, addedLines: added})
+	})
+}
+
+// LoadSnapshot loads the rules of the snapshot into the model, e.g. to compare them with the stored rules.
+func (a *Adapter) LoadSnapshot(model model.Model, id int64) error {
+	return a.LoadSnapshotCtx(context.Background(), model, id)
+}
+
+// LoadSnapshotCtx loads the rules of the snapshot into the model.
+// A failed load leaves the model unchanged.
+func (a *Adapter) LoadSnapshotCtx(ctx context.Context, model model.Model, id int64) error {
+	if a.snapshotTable == "" {
+		return errSnapshotsDisabled
+	}
+	if err := a.checkSnapshot(ctx, nil, id); err != nil {
+		return err
+	}
+	return a.loadPolicy(ctx, model, a.snapshotQuery(ctx, nil, id))
+}
+
+// DeleteSnapshot deletes the snapshot and its rules.
+func (a *Adapter) DeleteSnapshot(ctx context.Context, id int64) error {
+	if a.snapshotTable == "" {
+		return errSnapshotsDisabled
+	}
+	return a.dao.Transaction(ctx, func(ctx context.Context, tx gdb.TX) error {
+		result, err := a.logModel(ctx, a.snapshotTable).TX(tx).Where("id", id).Delete()
+		if err != nil {
+			return err
+		}
+		// A snapshot of another tenant is not deleted, nor are its rules.
+		if affected, err := result.RowsAffected(); err != nil || affected == 0 {
+			return err
+		}
+		_, err = a.model(ctx, a.snapshotRuleTable()).TX(tx).Where("snapshot_id", id).Delete()
+		return err
+	})
+}
+
+// snapshotRuleTable returns the name of the table holding the rules of the snapshots.
+func (a *Adapter) snapshotRuleTable() string {
+	return a.snapshotTable + "_rule"
+}
+
+// checkSnapshot returns an error if the snapshot does not exist.
+// A nil tx reads outside of a transaction.
+func (a *Adapter) checkSnapshot(ctx context.Context, tx gdb.TX, id int64) error {
+	m := a.logModel(ctx, a.snapshotTable)
+	if tx != nil {
+		m = m.TX(tx)
+	}
+	count, err := m.Where("id", id).Count()
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return fmt.Errorf("snapshot %d not found", id)
+	}
+	return nil
+}
+
+// snapshotQuery returns a function returning a new model of the rules of the snapshot, without fields.
+// A nil tx reads outside of a transaction.
+func (a *Adapter) snapshotQuery(ctx context.Context, tx gdb.TX, id int64) func() *gdb.Model {
+	return func() *gdb.Model {
+		m := a.model(ctx, a.snapshotRuleTable())
+		if tx != nil {
+			m = m.TX(tx)
+		}
+		return m.Where("snapshot_id", id)
+	}
+}
@@ -0,0 +1,100 @@
+package gfadapter
+
+import (
+	"context"
+	"testing"
+
+	"github.com/casbin/casbin/v2"
+	"github.com/casbin/casbin/v2/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSnapshot(t *testing.T) {
+	a, err := NewAdapterWithOptions(
+		WithTableName("test_casbin_rule_snapshotted"),
+		WithAutoCreateTable(true),
+		WithSnapshots("test_casbin_rule_snapshot"),
+	)
+	assert.Nil(t, err)
+	initPolicy(t, a)
+	ctx := context.Background()
+
+	id, err := a.Snapshot(ctx, "before bulk change")
+	assert.Nil(t, err)
+	snapshots, err := a.ListSnapshots(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, id, snapshots[0].ID)
+	assert.Equal(t, "before bulk change", snapshots[0].Label)
+	assert.Equal(t, 5, snapshots[0].Rules)
+
+	e, err := casbin.NewEnforcer("examples/rbac_model.conf", a)
+	assert.Nil(t, err)
+	_, err = e.RemovePolicy("alice", "data1", "read")
+	assert.Nil(t, err)
+	_, err = e.AddPolicy("carol", "data3", "read")
+	assert.Nil(t, err)
+	_, err = e.DeleteRole("data2_admin")
+	assert.Nil(t, err)
+
+	m, err := model.NewModelFromFile("examples/rbac_model.conf")
+	assert.Nil(t, err)
+	assert.Nil(t, a.LoadSnapshot(m, id))
+	policy, _ := m.GetPolicy("p", "p")
+	assert.True(t, arrayEqualsWithoutOrder([][]string{{"alice", "data1", "read"}, {"bob", "data2", "write"}, {"data2_admin", "data2", "read"}, {"data2_admin", "data2", "write"}}, policy))
+
+	assert.Nil(t, a.RestoreSnapshot(ctx, id))
+	assert.Nil(t, e.LoadPolicy())
+	testGetPolicy(t, e, [][]string{{"alice", "data1", "read"}, {"bob", "data2", "write"}, {"data2_admin", "data2", "read"}, {"data2_admin", "data2", "write"}})
+	grouping, _ := e.GetGroupingPolicy()
+	assert.Equal(t, [][]string{{"alice", "data2_admin"}}, grouping)
+
+	assert.Nil(t, a.DeleteSnapshot(ctx, id))
+	assert.NotNil(t, a.RestoreSnapshot(ctx, id))
+	assert.NotNil(t, a.LoadSnapshot(m, id))
+
+	_, err = (&Adapter{}).Snapshot(ctx, "")
+	assert.Equal(t, errSnapshotsDisabled, err)
+	_, err = (&Adapter{}).ListSnapshots(ctx)
+	assert.Equal(t, errSnapshotsDisabled, err)
+	assert.Equal(t, errSnapshotsDisabled, (&Adapter{}).RestoreSnapshot(ctx, id))
+}
+
+func TestRestoreEmptySnapshot(t *testing.T) {
+	a, err := NewAdapterWithOptions(
+		WithTableName("test_casbin_rule_snapshotted"),
+		WithAutoCreateTable(true),
+		WithSnapshots("test_casbin_rule_snapshot"),
+	)
+	assert.Nil(t, err)
+	ctx := context.Background()
+
+	e, err := casbin.NewEnforcer("examples/rbac_model.conf", a)
+	assert.Nil(t, err)
+	e.ClearPolicy()
+	assert.Nil(t, e.SavePolicy())
+	empty, err := a.Snapshot(ctx, "empty")
+	assert.Nil(t, err)
+	initPolicy(t, a)
+	full, err := a.Snapshot(ctx, "full")
+	assert.Nil(t, err)
+
+	// The latest snapshot is listed first.
+	snapshots, err := a.ListSnapshots(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, full, snapshots[0].ID)
+	assert.Equal(t, empty, snapshots[1].ID)
+	assert.Equal(t, 0, snapshots[1].Rules)
+
+	// Restoring a snapshot without rules removes all rules, a failed restore changes nothing.
+	assert.NotNil(t, a.RestoreSnapshot(ctx, full+1))
+	assert.Nil(t, e.LoadPolicy())
+	testGetPolicy(t, e, [][]string{{"alice", "data1", "read"}, {"bob", "data2", "write"}, {"data2_admin", "data2", "read"}, {"data2_admin", "data2", "write"}})
+	assert.Nil(t, a.RestoreSnapshot(ctx, empty))
+	assert.Nil(t, e.LoadPolicy())
+	testGetPolicy(t, e, [][]string{})
+	grouping, _ := e.GetGroupingPolicy()
+	assert.Empty(t, grouping)
+
+	assert.Nil(t, a.DeleteSnapshot(ctx, empty))
+	assert.Nil(t, a.DeleteSnapshot(ctx, full))
+}
@@ -0,0 +1,81 @@
+package gfadapter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gogf/gf/v2/database/gdb"
+)
+
+// errSoftDeleteDisabled is returned by the soft delete functions of an adapter created without WithSoftDelete.
+var errSoftDeleteDisabled = errors.New("soft delete is not enabled, create the adapter with WithSoftDelete")
+
+// deletedAtColumn marks a soft deleted rule, gdb turns deletes into updates of it and skips the marked rows.
+var deletedAtColumn = columnDef{name: "deleted_at", kind: kindTime}
+
+// deletedAtIndex speeds up PurgeDeleted.
+var deletedAtIndex = indexDef{name: "idx_deleted_at", columns: []string{deletedAtColumn.name}}
+
+// RestorePolicy undeletes a soft deleted rule.
+// The restoration is recorded like an added rule, so the watchers of the other instances add it to their enforcers.
+// It returns an error if the rule is not soft deleted.
+func (a *Adapter) RestorePolicy(ctx context.Context, sec string, ptype string, rule []string) error {
+	if !a.softDelete {
+		return errSoftDeleteDisabled
+	}
+	a, err := a.forTenant(ctx)
+	if err != nil {
+		return err
+	}
+	line, err := a.savePolicyLine(ptype, rule)
+	if err != nil {
+		return err
+	}
+	hashColumn := a.dao.Columns().RuleHash
+	return a.dao.Transaction(ctx, func(ctx context.Context, tx gdb.TX) error {
+		result, err := a.dao.Ctx(ctx).TX(tx).Unscoped().
+			Data(deletedAtColumn.name, nil).
+			Where(hashColumn, line[hashColumn]).
+			WhereNotNull(deletedAtColumn.name).
+			Update()
+		if err != nil {
+			return err
+		}
+		affected, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if affected == 0 {
+			return fmt.Errorf("the rule is not deleted: %v", rule)
+		}
+		// A rule outside its validity window is not loaded, so it is kept out of the change log like when it was added.
+		valid, err := a.whereValid(a.dao.Ctx(ctx).TX(tx), time.Now()).Where(hashColumn, line[hashColumn]).Count()
+		if err != nil {
+			return err
+		}
+		return a.recordChange(ctx, tx, change{op: ChangeAdd, sec: sec, ptype: ptype, rules: [][]string{rule}, auditOnly: valid == 0})
+	})
+}
+
+// PurgeDeleted permanently deletes the rules soft deleted before the given time and returns the number of deleted rules.
+// A zero time deletes all soft deleted rules.
+func (a *Adapter) PurgeDeleted(ctx context.Context, before time.Time) (int64, error) {
+	if !a.softDelete {
+		return 0, errSoftDeleteDisabled
+	}
+	a, err := a.forTenant(ctx)
+	if err != nil {
+		return 0, err
+	}
+	m := a.dao.Ctx(ctx).Unscoped().WhereNotNull(deletedAtColumn.name)
+	if !before.IsZero() {
+		m = m.WhereLT(deletedAtColumn.name, before)
+	}
+	result, err := m.Delete()
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,89 @@
+package gfadapter
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/casbin/casbin/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSoftDelete(t *testing.T) {
+	a, err := NewAdapterWithOptions(
+		WithTableName("test_casbin_rule_soft_delete"),
+		WithAutoCreateTable(true),
+		WithSoftDelete(true),
+	)
+	assert.Nil(t, err)
+	ctx := context.Background()
+	_, err = a.PurgeDeleted(ctx, time.Time{})
+	assert.Nil(t, err)
+	initPolicy(t, a)
+
+	e, err := casbin.NewEnforcer("examples/rbac_model.conf", a)
+	assert.Nil(t, err)
+	_, err = e.RemovePolicy("alice", "data1", "read")
+	assert.Nil(t, err)
+	_, err = e.RemoveFilteredPolicy(0, "data2_admin")
+	assert.Nil(t, err)
+
+	// The removed rows are kept but not loaded.
+	count, err := a.dao.Ctx(ctx).Unscoped().Count()
+	assert.Nil(t, err)
+	assert.Equal(t, 5, count)
+	assert.Nil(t, e.LoadPolicy())
+	testGetPolicy(t, e, [][]string{{"bob", "data2", "write"}})
+
+	assert.Nil(t, a.RestorePolicy(ctx, "p", "p", []string{"alice", "data1", "read"}))
+	assert.NotNil(t, a.RestorePolicy(ctx, "p", "p", []string{"alice", "data1", "read"}))
+
+	// Adding or updating to a deleted rule undeletes its row.
+	_, err = e.AddPolicy("data2_admin", "data2", "read")
+	assert.Nil(t, err)
+	_, err = e.UpdatePolicy([]string{"bob", "data2", "write"}, []string{"data2_admin", "data2", "write"})
+	assert.Nil(t, err)
+	assert.Nil(t, e.LoadPolicy())
+	testGetPolicy(t, e, [][]string{{"alice", "data1", "read"}, {"data2_admin", "data2", "read"}, {"data2_admin", "data2", "write"}})
+
+	purged, err := a.PurgeDeleted(ctx, time.Now().Add(time.Minute))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), purged)
+	count, err = a.dao.Ctx(ctx).Unscoped().Count()
+	assert.Nil(t, err)
+	assert.Equal(t, 4, count)
+
+	_, err = (&Adapter{}).PurgeDeleted(ctx, time.Time{})
+	assert.Equal(t, errSoftDeleteDisabled, err)
+	assert.Equal(t, errSoftDeleteDisabled, (&Adapter{}).RestorePolicy(ctx, "p", "p", []string{"alice", "data1", "read"}))
+}
+
+func TestRestorePolicyNotDeleted(t *testing.T) {
+	a, err := NewAdapterWithOptions(
+		WithTableName("test_casbin_rule_soft_delete"),
+		WithAutoCreateTable(true),
+		WithSoftDelete(true),
+	)
+	assert.Nil(t, err)
+	ctx := context.Background()
+	_, err = a.PurgeDeleted(ctx, time.Time{})
+	assert.Nil(t, err)
+	initPolicy(t, a)
+
+	// A rule that was never deleted or never added cannot be restored.
+	assert.NotNil(t, a.RestorePolicy(ctx, "p", "p", []string{"alice", "data1", "read"}))
+	assert.NotNil(t, a.RestorePolicy(ctx, "p", "p", []string{"carol", "data3", "read"}))
+	count, err := a.dao.Ctx(ctx).Unscoped().Count()
+	assert.Nil(t, err)
+	assert.Equal(t, 5, count)
+
+	// Rules deleted after the given time are kept by PurgeDeleted.
+	assert.Nil(t, a.RemovePolicyCtx(ctx, "p", "p", []string{"alice", "data1", "read"}))
+	purged, err := a.PurgeDeleted(ctx, time.Now().Add(-time.Minute))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), purged)
+	assert.Nil(t, a.RestorePolicy(ctx, "p", "p", []string{"alice", "data1", "read"}))
+	purged, err = a.PurgeDeleted(ctx, time.Time{})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), purged)
+}
@@ -0,0 +1,104 @@
+package gfadapter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/casbin/casbin/v2/constant"
+	"github.com/casbin/casbin/v2/model"
+	"github.com/gogf/gf/v2/util/gconv"
+)
+
+// LoadSubjectPolicy loads the rules reachable from one subject into the model, for enforcers scoped to a request.
+// The role links of the "g" role definition are followed from the subject to all its roles,
+// then the policy rules whose subject is the subject or one of its roles are loaded.
+// If domains are given, the links and the policy rules having a domain are restricted to them.
+// The rules of the other role definitions, like "g2", are loaded completely.
+// The model is marked as filtered, so SavePolicy is refused.
+func (a *Adapter) LoadSubjectPolicy(ctx context.Context, model model.Model, subject string, domains ...string) error {
+	a, err := a.forTenant(ctx)
+	if err != nil {
+		return err
+	}
+	if subject == "" {
+		return errors.New("no subject given")
+	}
+	// The roles and the rules are read from the same node.
+	ctx = a.withLoadNode(ctx)
+	roles, err := a.subjectRoles(ctx, model, subject, domains)
+	if err != nil {
+		return err
+	}
+
+	columns := domainColumns(model)
+	scoped := func(ptype string, cond Cond) Cond {
+		if column, ok := columns[ptype]; ok && len(domains) > 0 {
+			return And(cond, Eq(column, domains...))
+		}
+		return cond
+	}
+	sections := make(map[string]Cond)
+	for ptype := range model["p"] {
+		column := a.dao.Columns().V0
+		if index, err := model.GetFieldIndex(ptype, constant.SubjectIndex); err == nil {
+			column = fmt.Sprintf("v%d", index)
+		}
+		sections[ptype] = scoped(ptype, Eq(column, roles...))
+	}
+	if _, ok := model["g"]["g"]; ok {
+		sections["g"] = scoped("g", Eq(a.dao.Columns().V0, roles...))
+		// The key "g" stands for the whole section, the other role definitions are not restricted.
+		for ptype := range model["g"] {
+			if ptype != "g" {
+				sections[ptype] = nil
+			}
+		}
+	}
+	return a.LoadFilteredPolicyCtx(ctx, model, Filter{Sections: sections})
+}
+
+// subjectRoles returns the subject and all roles it inherits through the "g" rules loaded by LoadPolicy, sorted.
+func (a *Adapter) subjectRoles(ctx context.Context, model model.Model, subject string, domains []string) ([]string, error) {
+	names := map[string]bool{subject: true}
+	if _, ok := model["g"]["g"]; !ok {
+		return []string{subject}, nil
+	}
+	domainColumn, hasDomain := domainColumns(model)["g"]
+
+	cols := a.dao.Columns()
+	query := a.policyQuery(ctx)
+	frontier := []string{subject}
+	for len(frontier) > 0 {
+		members := frontier
+		frontier = nil
+		for start := 0; start < len(members); start += flushEvery {
+			end := min(start+flushEvery, len(members))
+			qs := query().
+				Fields(cols.V1).
+				Where(cols.Ptype, "g").
+				WhereIn(cols.V0, members[start:end])
+			if hasDomain && len(domains) > 0 {
+				qs = qs.WhereIn(domainColumn, domains)
+			}
+			values, err := qs.Distinct().Array()
+			if err != nil {
+				return nil, err
+			}
+			for _, role := range gconv.Strings(values) {
+				if role != "" && !names[role] {
+					names[role] = true
+					frontier = append(frontier, role)
+				}
+			}
+		}
+	}
+
+	roles := make([]string, 0, len(names))
+	for name := range names {
+		roles = append(roles, name)
+	}
+	sort.Strings(roles)
+	return roles, nil
+}
@@ -0,0 +1,163 @@
+package gfadapter
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/gogf/gf/v2/database/gdb"
+)
+
+// ensureTable creates the table, the enabled auxiliary tables and their missing indexes if they do not exist yet.
+// A rule table created here is recorded with the latest schema version, an existing one is left to Migrate.
+// It is safe to be called concurrently and does nothing once it has succeeded.
+func (a *Adapter) ensureTable(ctx context.Context) error {
+	a.state.createMu.Lock()
+	defer a.state.createMu.Unlock()
+	if a.state.tableReady {
+		return nil
+	}
+	// The tenant tables are created on first use by forTenant.
+	if a.tenantTable == "" {
+		created, err := createTableIfNotExists(ctx, a.dao.DB(), a.ruleTableDef())
+		if err != nil {
+			return err
+		}
+		if created {
+			err = a.recordCreatedTable(ctx)
+		} else {
+			err = a.recordDetectedVersion(ctx)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	for _, def := range a.auxTableDefs() {
+		if _, err := createTableIfNotExists(ctx, a.dao.DB(), def); err != nil {
+			return err
+		}
+	}
+	a.state.tableReady = true
+	return nil
+}
+
+// ruleTableDef returns the definition of the rule table of the adapter.
+func (a *Adapter) ruleTableDef() tableDef {
+	t := ruleTableDef(a.dao.Table(), len(a.valueColumns), a.columnLength)
+	if a.validity {
+		t.columns = append(t.columns, validFromColumn, validUntilColumn)
+		t.indexes = append(t.indexes, validUntilIndex)
+	}
+	if a.softDelete {
+		t.columns = append(t.columns, deletedAtColumn)
+		t.indexes = append(t.indexes, deletedAtIndex)
+	}
+	if a.tenantMode {
+		t.columns = append(t.columns, tenantColumn)
+		for i, idx := range t.indexes {
+			if idx.name == ruleHashIndex.name {
+				t.indexes[i] = tenantRuleHashIndex
+			}
+		}
+	}
+	return t
+}
+
+// createTableIfNotExists creates the given table and its indexes, skipping the ones that already exist.
+// Indexes on columns missing from an existing table are skipped as well, they are added by a migration.
+// An object created concurrently by another process in the meantime is not reported as an error.
+func createTableIfNotExists(ctx context.Context, db gdb.DB, t tableDef) (created bool, err error) {
+	dbType := db.GetConfig().Type
+	if !isSupportedDBType(dbType) {
+		return false, errors.New("invalid db type")
+	}
+
+	exists, err := tableExists(ctx, db, t.name)
+	if err != nil {
+		return false, err
+	}
+	if !exists {
+		if _, err = db.Exec(ctx, createTableSQL(dbType, t)); err != nil {
+			if exists, _ = tableExists(ctx, db, t.name); !exists {
+				return false, err
+			}
+		} else {
+			created = true
+		}
+	}
+
+	if !supportsIndexes(dbType) {
+		return created, nil
+	}
+	fields, err := db.TableFields(ctx, t.name)
+	if err != nil {
+		return created, err
+	}
+	for _, idx := range t.indexes {
+		if !hasFields(fields, idx.columns) {
+			continue
+		}
+		name := indexName(dbType, t.name, idx)
+		exists, err = indexExists(ctx, db, t.name, name)
+		if err != nil {
+			return created, err
+		}
+		if exists {
+			continue
+		}
+		if _, err = db.Exec(ctx, createIndexSQL(dbType, t.name, idx)); err != nil {
+			if exists, _ = indexExists(ctx, db, t.name, name); !exists {
+				return created, err
+			}
+		}
+	}
+	return created, nil
+}
+
+// tableExists checks the table metadata of the database for the given table.
+func tableExists(ctx context.Context, db gdb.DB, tableName string) (bool, error) {
+	// The table list is not cached by gdb, so tables created by other processes are seen.
+	tables, err := db.Tables(ctx)
+	if err != nil {
+		return false, err
+	}
+	for _, table := range tables {
+		if strings.EqualFold(table, tableName) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// indexExists checks whether the given index exists on the table.
+func indexExists(ctx context.Context, db gdb.DB, tableName string, name string) (bool, error) {
+	sql := indexExistsSQL(db.GetConfig().Type)
+	if sql == "" {
+		return false, nil
+	}
+	value, err := db.GetValue(ctx, sql, tableName, name)
+	if err != nil {
+		return false, err
+	}
+	return value.Int() > 0, nil
+}
+
+// hasFields reports whether all the given columns are table fields.
+func hasFields(fields map[string]*gdb.TableField, columns []string) bool {
+	for _, column := range columns {
+		if tableField(fields, column) == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// tableField returns the table field of the given column ignoring case, or nil if it does not exist.
+func tableField(fields map[string]*gdb.TableField, column string) *gdb.TableField {
+	for name, field := range fields {
+		if strings.EqualFold(name, column) {
+			return field
+		}
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package gfadapter
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/gogf/gf/v2/database/gdb"
+)
+
+// errNoTenant is returned by the operations of an adapter created with WithTenantScope("")
+// if the context carries no tenant.
+var errNoTenant = errors.New("no tenant in the context, set it with WithTenant")
+
+// tenantContextKey is the context key of the tenant.
+type tenantContextKey struct{}
+
+// tenantColumn holds the tenant of a rule.
+var tenantColumn = columnDef{name: "tenant_id", kind: kindString, size: 64}
+
+// tenantRuleHashIndex makes rules unique within a tenant, it replaces ruleHashIndex in the tenant mode.
+var tenantRuleHashIndex = indexDef{name: "uk_tenant_rule_hash", columns: []string{"tenant_id", "rule_hash"}, unique: true}
+
+// tenantIndex speeds up reading the audit log and the snapshots of a tenant.
+var tenantIndex = indexDef{name: "idx_tenant_id", columns: []string{"tenant_id"}}
+
+// withTenantColumn adds the tenant column to the definition of a change log, audit or snapshot table.
+func withTenantColumn(t tableDef) tableDef {
+	t.columns = append(t.columns[:len(t.columns):len(t.columns)], tenantColumn)
+	t.indexes = append(t.indexes[:len(t.indexes):len(t.indexes)], tenantIndex)
+	return t
+}
+
+// WithTenant returns a context whose operations on an adapter created with WithTenantScope("") are scoped to tenant.
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantContextKey{}, tenant)
+}
+
+// tenant returns the tenant of the operations with ctx.
+func (a *Adapter) tenant(ctx context.Context) (string, error) {
+	if a.tenantID != "" {
+		return a.tenantID, nil
+	}
+	if tenant, _ := ctx.Value(tenantContextKey{}).(string); tenant != "" {
+		return tenant, nil
+	}
+	return "", errNoTenant
+}
+
+// tenantScope restricts the rule queries to the tenant of ctx and stores the tenant of inserted rules.
+// All operations fail if ctx carries no tenant.
+func (a *Adapter) tenantScope(ctx context.Context, m *gdb.Model) *gdb.Model {
+	tenant, err := a.tenant(ctx)
+	if err != nil {
+		return failingModel(m, err)
+	}
+	return m.Where(tenantColumn.name, tenant).Hook(gdb.HookHandler{
+		Insert: func(ctx context.Context, in *gdb.HookInsertInput) (sql.Result, error) {
+			for _, data := range in.Data {
+				data[tenantColumn.name] = tenant
+			}
+			return in.Next(ctx)
+		},
+	})
+}
+
+// logModel returns a model of the change log, audit or snapshot table like model,
+// restricted to the tenant of ctx and storing it in the inserted rows if the rows record their tenant.
+func (a *Adapter) logModel(ctx context.Context, tableName string) *gdb.Model {
+	m := a.model(ctx, tableName)
+	if !a.tenantLogs {
+		return m
+	}
+	return a.tenantScope(ctx, m)
+}
+
+// failingModel returns m with all operations failing with err.
+func failingModel(m *gdb.Model, err error) *gdb.Model {
+	return m.Hook(gdb.HookHandler{
+		Select: func(ctx context.Context, in *gdb.HookSelectInput) (gdb.Result, error) {
+			return nil, err
+		},
+		Insert: func(ctx context.Context, in *gdb.HookInsertInput) (sql.Result, error) {
+			return nil, err
+		},
+		Update: func(ctx context.Context, in *gdb.HookUpdateInput) (sql.Result, error) {
+			return nil, err
+		},
+		Delete: func(ctx context.Context, in *gdb.HookDeleteInput) (sql.Result, error) {
+			return nil, err
+		},
+	})
+}
+
+// unscoped returns a copy of the adapter whose rule queries are not restricted to a tenant, for migrations.
+func (a *Adapter) unscoped() *Adapter {
+	c := *a
+	c.dao = a.dao.WithScope(nil)
+	return &c
+}
@@ -0,0 +1,214 @@
+package gfadapter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/gogf/gf/v2/database/gdb"
+
+	"github.com/yclw/gf-casbin-adapter/dao"
+)
+
+// errTenantTablesDisabled is returned by the tenant table functions of an adapter created without WithTenantTables.
+var errTenantTablesDisabled = errors.New("tenant tables are not enabled, create the adapter with WithTenantTables")
+
+// errTenantTableUnresolved is returned by an operation reaching the rule table before the table of the tenant is resolved.
+var errTenantTableUnresolved = errors.New("the rule table of the tenant is not resolved")
+
+// tenantNameRegex matches the tenants allowed in table names.
+var tenantNameRegex = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
+// unresolvedTableScope makes the operations on the rule table named by the tenant table pattern fail.
+func unresolvedTableScope(ctx context.Context, m *gdb.Model) *gdb.Model {
+	return failingModel(m, errTenantTableUnresolved)
+}
+
+// forTenant returns the adapter working on the rule table of the tenant of ctx, or a if the rule table is fixed.
+// The table is created on first use and its DAO is cached.
+func (a *Adapter) forTenant(ctx context.Context) (*Adapter, error) {
+	if a.tenantTable == "" {
+		return a, nil
+	}
+	tenant, err := a.tenant(ctx)
+	if err != nil {
+		return nil, err
+	}
+	d, err := a.tenantDao(ctx, tenant)
+	if err != nil {
+		return nil, err
+	}
+	c := *a
+	c.tenantTable = ""
+	c.dao = d
+	if tx := a.dao.TX(); tx != nil {
+		c.dao = d.WithTX(tx)
+	}
+	return &c, nil
+}
+
+// tenantDao returns the cached DAO of the rule table of tenant, creating the table if it does not exist yet.
+func (a *Adapter) tenantDao(ctx context.Context, tenant string) (*dao.CasbinRuleDao, error) {
+	s := a.state
+	s.tenantMu.Lock()
+	defer s.tenantMu.Unlock()
+	if d, ok := s.tenantDaos[tenant]; ok {
+		return d, nil
+	}
+	c, err := a.tenantAdapter(tenant)
+	if err != nil {
+		return nil, err
+	}
+	c.dao = c.dao.WithTX(nil)
+	// The table is created outside the transaction of the operation, DDL commits it implicitly on MySQL.
+	ctx = gdb.WithoutTX(ctx, c.dao.DB().GetGroup())
+	created, err := createTableIfNotExists(ctx, c.dao.DB(), c.ruleTableDef())
+	if err != nil {
+		return nil, err
+	}
+	if created {
+		if err = c.recordCreatedTable(ctx); err != nil {
+			return nil, err
+		}
+	}
+	if s.tenantDaos == nil {
+		s.tenantDaos = make(map[string]*dao.CasbinRuleDao)
+	}
+	s.tenantDaos[tenant] = c.dao
+	return c.dao, nil
+}
+
+// tenantAdapter returns a copy of the adapter working on the rule table of tenant, without creating the table.
+func (a *Adapter) tenantAdapter(tenant string) (*Adapter, error) {
+	if !tenantNameRegex.MatchString(tenant) {
+		return nil, fmt.Errorf("invalid tenant %q, only letters, digits and underscores are allowed", tenant)
+	}
+	tableName := fmt.Sprintf(a.tenantTable, tenant)
+	reserved := []string{a.migrationTable}
+	for _, def := range a.auxTableDefs() {
+		reserved = append(reserved, def.name)
+	}
+	for _, name := range reserved {
+		if strings.EqualFold(tableName, name) {
+			return nil, fmt.Errorf("invalid tenant %q, its table %s is used by the adapter", tenant, tableName)
+		}
+	}
+	c := *a
+	c.tenantTable = ""
+	c.dao = a.dao.WithTable(tableName).WithScope(nil)
+	return &c, nil
+}
+
+// withTenantTable returns the adapter working on the rule table of the tenant of ctx, or a if the rule table is fixed.
+// Unlike forTenant the table is not created.
+func (a *Adapter) withTenantTable(ctx context.Context) (*Adapter, error) {
+	if a.tenantTable == "" {
+		return a, nil
+	}
+	tenant, err := a.tenant(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return a.tenantAdapter(tenant)
+}
+
+// Tenants returns the tenants having a rule table, in ascending order.
+// Only the tables created by the adapter or by Migrate are listed, as they are recorded in the migration table.
+func (a *Adapter) Tenants(ctx context.Context) ([]string, error) {
+	if a.tenantTable == "" {
+		return nil, errTenantTablesDisabled
+	}
+	db := a.dao.DB()
+	exists, err := tableExists(ctx, db, a.migrationTable)
+	if err != nil || !exists {
+		return nil, err
+	}
+	recorded, err := db.Model(a.migrationTable).Ctx(ctx).Distinct().Fields("table_name").Array()
+	if err != nil {
+		return nil, err
+	}
+	tables, err := db.Tables(ctx)
+	if err != nil {
+		return nil, err
+	}
+	existing := make(map[string]bool, len(tables))
+	for _, table := range tables {
+		existing[strings.ToLower(table)] = true
+	}
+
+	prefix, suffix, _ := strings.Cut(a.tenantTable, "%s")
+	var tenants []string
+	for _, value := range recorded {
+		table := value.String()
+		if !existing[strings.ToLower(table)] || len(table) <= len(prefix)+len(suffix) ||
+			!strings.HasPrefix(table, prefix) || !strings.HasSuffix(table, suffix) {
+			continue
+		}
+		if tenant := table[len(prefix) : len(table)-len(suffix)]; tenantNameRegex.MatchString(tenant) {
+			tenants = append(tenants, tenant)
+		}
+	}
+	sort.Strings(tenants)
+	return tenants, nil
+}
+
+// DropTenant drops the rule table of tenant and its migration records.
+// The rules of the tenant in the change log, audit and snapshot tables are kept.
+func (a *Adapter) DropTenant(ctx context.Context, tenant string) error {
+	if a.tenantTable == "" {
+		return errTenantTablesDisabled
+	}
+	c, err := a.tenantAdapter(tenant)
+	if err != nil {
+		return err
+	}
+	db := c.dao.DB()
+	tableName := c.dao.Table()
+	ctx = gdb.WithoutTX(ctx, db.GetGroup())
+
+	s := a.state
+	s.tenantMu.Lock()
+	defer s.tenantMu.Unlock()
+	delete(s.tenantDaos, tenant)
+
+	exists, err := tableExists(ctx, db, tableName)
+	if err != nil {
+		return err
+	}
+	if exists {
+		if _, err = db.Exec(ctx, "DROP TABLE "+tableName); err != nil {
+			return err
+		}
+	}
+	if err = db.GetCore().ClearTableFields(ctx, tableName); err != nil {
+		return err
+	}
+	exists, err = tableExists(ctx, db, a.migrationTable)
+	if err != nil || !exists {
+		return err
+	}
+	_, err = db.Model(a.migrationTable).Ctx(ctx).Where("table_name", tableName).Delete()
+	return err
+}
+
+// MigrateTenants upgrades the rule tables of all tenants listed by Tenants to the latest schema version.
+// It stops at the first tenant whose migration fails, the tenants migrated before keep their new version.
+func (a *Adapter) MigrateTenants(ctx context.Context) error {
+	tenants, err := a.Tenants(ctx)
+	if err != nil {
+		return err
+	}
+	for _, tenant := range tenants {
+		c, err := a.tenantAdapter(tenant)
+		if err != nil {
+			return err
+		}
+		if err = c.Migrate(ctx); err != nil {
+			return fmt.Errorf("tenant %s: %w", tenant, err)
+		}
+	}
+	return nil
+}
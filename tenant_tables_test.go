@@ -0,0 +1,137 @@
+package gfadapter
+
+import (
+	"context"
+	"testing"
+
+	"github.com/casbin/casbin/v2/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTenantTables(t *testing.T) {
+	a, err := NewAdapterWithOptions(
+		WithAutoCreateTable(true),
+		WithTenantTables("test_casbin_rule_tenant_%s"),
+	)
+	assert.Nil(t, err)
+	ctx := context.Background()
+	for _, tenant := range []string{"t1", "t2"} {
+		assert.Nil(t, a.DropTenant(ctx, tenant))
+	}
+
+	m, err := model.NewModelFromFile("examples/rbac_model.conf")
+	assert.Nil(t, err)
+	loadPolicy := func(ctx context.Context) [][]string {
+		m.ClearPolicy()
+		assert.Nil(t, a.LoadPolicyCtx(ctx, m))
+		policy, _ := m.GetPolicy("p", "p")
+		return policy
+	}
+
+	// The tables are created on first use.
+	ctx1 := WithTenant(ctx, "t1")
+	ctx2 := WithTenant(ctx, "t2")
+	assert.Empty(t, loadPolicy(ctx1))
+	assert.Nil(t, a.AddPolicyCtx(ctx1, "p", "p", []string{"alice", "data1", "read"}))
+	assert.Nil(t, a.AddPolicyCtx(ctx2, "p", "p", []string{"alice", "data1", "read"}))
+	assert.Nil(t, a.AddPolicyCtx(ctx2, "p", "p", []string{"bob", "data2", "write"}))
+	assert.Nil(t, a.RemovePolicyCtx(ctx1, "p", "p", []string{"alice", "data1", "read"}))
+	assert.Equal(t, [][]string{{"alice", "data1", "read"}, {"bob", "data2", "write"}}, loadPolicy(ctx2))
+	assert.Empty(t, loadPolicy(ctx1))
+
+	tenants, err := a.Tenants(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"t1", "t2"}, tenants)
+	assert.Nil(t, a.MigrateTenants(ctx))
+	version, err := a.SchemaVersion(ctx2)
+	assert.Nil(t, err)
+	assert.Equal(t, latestSchemaVersion(), version)
+
+	// A dropped table is created again on the next use.
+	assert.Nil(t, a.DropTenant(ctx, "t2"))
+	tenants, err = a.Tenants(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"t1"}, tenants)
+	version, err = a.SchemaVersion(ctx2)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, version)
+	assert.Empty(t, loadPolicy(ctx2))
+
+	assert.Equal(t, errNoTenant, a.LoadPolicyCtx(ctx, m))
+	assert.NotNil(t, a.LoadPolicyCtx(WithTenant(ctx, "t1; DROP TABLE casbin_rule"), m))
+}
+
+func TestTenantTablesLogs(t *testing.T) {
+	a, err := NewAdapterWithOptions(
+		WithAutoCreateTable(true),
+		WithTenantTables("test_casbin_rule_tenant_%s"),
+		WithChangeLog("test_casbin_rule_tenant_tables_change"),
+		WithAuditLog("test_casbin_rule_tenant_tables_audit"),
+		WithSnapshots("test_casbin_rule_tenant_tables_snapshot"),
+	)
+	assert.Nil(t, err)
+	ctx := context.Background()
+	ctx1 := WithTenant(ctx, "t1")
+	ctx2 := WithTenant(ctx, "t2")
+	for _, tenant := range []string{"t1", "t2"} {
+		assert.Nil(t, a.DropTenant(ctx, tenant))
+	}
+
+	// The changes of the other tenant move the sequence number on but are not applied.
+	since, err := a.LatestChange(ctx)
+	assert.Nil(t, err)
+	m, err := model.NewModelFromFile("examples/rbac_model.conf")
+	assert.Nil(t, err)
+	assert.Nil(t, a.AddPolicyCtx(ctx2, "p", "p", []string{"bob", "data2", "write"}))
+	assert.Nil(t, a.AddPolicyCtx(ctx1, "p", "p", []string{"alice", "data1", "read"}))
+	assert.Nil(t, a.AddPolicyCtx(ctx2, "p", "p", []string{"carol", "data3", "read"}))
+	latest, err := a.LatestChange(ctx)
+	assert.Nil(t, err)
+	cursor, err := a.LoadPolicySince(ctx1, m, since)
+	assert.Nil(t, err)
+	assert.Equal(t, latest, cursor)
+	policy, _ := m.GetPolicy("p", "p")
+	assert.Equal(t, [][]string{{"alice", "data1", "read"}}, policy)
+
+	// The audit log and the snapshots of the other tenant are not seen.
+	entries, err := a.AuditLog(ctx1, AuditQuery{Limit: 1})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"alice", "data1", "read"}, entries[0].NewRule)
+	_, err = a.AuditLog(ctx, AuditQuery{})
+	assert.Equal(t, errNoTenant, err)
+	id, err := a.Snapshot(ctx2, "t2")
+	assert.Nil(t, err)
+	snapshots, err := a.ListSnapshots(ctx1)
+	assert.Nil(t, err)
+	for _, snapshot := range snapshots {
+		assert.NotEqual(t, id, snapshot.ID)
+	}
+	assert.NotNil(t, a.RestoreSnapshot(ctx1, id))
+	snapshots, err = a.ListSnapshots(ctx2)
+	assert.Nil(t, err)
+	assert.Equal(t, id, snapshots[0].ID)
+	assert.Equal(t, 2, snapshots[0].Rules)
+	assert.Nil(t, a.DeleteSnapshot(ctx2, id))
+}
+
+func TestTenantAdapter(t *testing.T) {
+	a, err := NewAdapterWithOptions(WithTenantTables(""), WithChangeLog(""))
+	assert.Nil(t, err)
+
+	c, err := a.tenantAdapter("acme")
+	assert.Nil(t, err)
+	assert.Equal(t, "casbin_rule_acme", c.dao.Table())
+	assert.Empty(t, c.tenantTable)
+	_, err = a.tenantAdapter("acme-corp")
+	assert.NotNil(t, err)
+	// The table of the tenant "change" would be the change log table.
+	_, err = a.tenantAdapter("change")
+	assert.NotNil(t, err)
+
+	_, err = NewAdapterWithOptions(WithTenantTables("casbin_rule"))
+	assert.NotNil(t, err)
+	_, err = NewAdapterWithOptions(WithTenantTables(""), WithTenantScope("acme"))
+	assert.NotNil(t, err)
+	_, err = (&Adapter{}).Tenants(context.Background())
+	assert.Equal(t, errTenantTablesDisabled, err)
+}
@@ -0,0 +1,151 @@
+package gfadapter
+
+import (
+	"context"
+	"testing"
+
+	"github.com/casbin/casbin/v2"
+	"github.com/casbin/casbin/v2/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTenantScope(t *testing.T) {
+	newTenantAdapter := func(tenant string, opts ...Option) *Adapter {
+		a, err := NewAdapterWithOptions(append([]Option{
+			WithTableName("test_casbin_rule_tenant"),
+			WithAutoCreateTable(true),
+			WithTenantScope(tenant),
+		}, opts...)...)
+		assert.Nil(t, err)
+		return a
+	}
+	a1 := newTenantAdapter("t1")
+	a2 := newTenantAdapter("t2")
+	// The same rules are stored for both tenants.
+	initPolicy(t, a1)
+	initPolicy(t, a2)
+
+	e1, err := casbin.NewEnforcer("examples/rbac_model.conf", a1)
+	assert.Nil(t, err)
+	_, err = e1.RemovePolicy("alice", "data1", "read")
+	assert.Nil(t, err)
+	_, err = e1.AddPolicy("carol", "data3", "read")
+	assert.Nil(t, err)
+
+	e2, err := casbin.NewEnforcer("examples/rbac_model.conf", a2)
+	assert.Nil(t, err)
+	testGetPolicy(t, e2, [][]string{{"alice", "data1", "read"}, {"bob", "data2", "write"}, {"data2_admin", "data2", "read"}, {"data2_admin", "data2", "write"}})
+
+	// Saving the rules of a tenant taken from the context only replaces the rules of that tenant, also when truncating.
+	a3 := newTenantAdapter("", WithSaveMode(SaveModeTruncate))
+	ctx := WithTenant(context.Background(), "t3")
+	m, err := model.NewModelFromFile("examples/rbac_model.conf")
+	assert.Nil(t, err)
+	_, err = m.AddPoliciesWithAffected("p", "p", [][]string{{"dave", "data4", "read"}})
+	assert.Nil(t, err)
+	assert.Nil(t, a3.SavePolicyCtx(ctx, m))
+	m.ClearPolicy()
+	assert.Nil(t, a3.LoadPolicyCtx(ctx, m))
+	policy, _ := m.GetPolicy("p", "p")
+	assert.Equal(t, [][]string{{"dave", "data4", "read"}}, policy)
+
+	assert.Nil(t, e1.LoadPolicy())
+	testGetPolicy(t, e1, [][]string{{"bob", "data2", "write"}, {"carol", "data3", "read"}, {"data2_admin", "data2", "read"}, {"data2_admin", "data2", "write"}})
+	assert.Nil(t, e2.LoadPolicy())
+	testGetPolicy(t, e2, [][]string{{"alice", "data1", "read"}, {"bob", "data2", "write"}, {"data2_admin", "data2", "read"}, {"data2_admin", "data2", "write"}})
+
+	// Without a tenant in the context nothing is read or written.
+	assert.NotNil(t, a3.LoadPolicyCtx(context.Background(), m))
+	assert.NotNil(t, a3.AddPolicyCtx(context.Background(), "p", "p", []string{"eve", "data5", "read"}))
+	tenant, err := a3.tenant(context.Background())
+	assert.Equal(t, errNoTenant, err)
+	assert.Empty(t, tenant)
+}
+
+func TestTenantLogTableDefs(t *testing.T) {
+	a := &Adapter{changeLogTable: "change", auditTable: "audit", snapshotTable: "snapshot", columnLength: 100}
+	for _, def := range a.auxTableDefs() {
+		assert.Nil(t, tableDefColumn(def, tenantColumn.name), def.name)
+	}
+	a.tenantLogs = true
+	for _, def := range a.auxTableDefs() {
+		if def.name == a.snapshotRuleTable() {
+			assert.Nil(t, tableDefColumn(def, tenantColumn.name))
+			continue
+		}
+		assert.NotNil(t, tableDefColumn(def, tenantColumn.name), def.name)
+		assert.Contains(t, def.indexes, tenantIndex)
+	}
+}
+
+// tableDefColumn returns the column of the table definition with the given name, or nil if there is none.
+func tableDefColumn(def tableDef, name string) *columnDef {
+	for i := range def.columns {
+		if def.columns[i].name == name {
+			return &def.columns[i]
+		}
+	}
+	return nil
+}
+
+func TestTenantScopeLogs(t *testing.T) {
+	newTenantAdapter := func(tenant string) *Adapter {
+		a, err := NewAdapterWithOptions(
+			WithTableName("test_casbin_rule_tenant_logged"),
+			WithAutoCreateTable(true),
+			WithTenantScope(tenant),
+			WithChangeLog("test_casbin_rule_tenant_change"),
+			WithAuditLog("test_casbin_rule_tenant_audit"),
+			WithSnapshots("test_casbin_rule_tenant_snapshot"),
+		)
+		assert.Nil(t, err)
+		return a
+	}
+	a1 := newTenantAdapter("t1")
+	a2 := newTenantAdapter("t2")
+	initPolicy(t, a1)
+	initPolicy(t, a2)
+	ctx := context.Background()
+
+	// The changes of the other tenant move the sequence number on but are not applied.
+	since, err := a1.LatestChange(ctx)
+	assert.Nil(t, err)
+	m, err := model.NewModelFromFile("examples/rbac_model.conf")
+	assert.Nil(t, err)
+	assert.Nil(t, a1.LoadPolicyCtx(ctx, m))
+	assert.Nil(t, a2.AddPolicyCtx(ctx, "p", "p", []string{"carol", "data3", "read"}))
+	assert.Nil(t, a1.AddPolicyCtx(ctx, "p", "p", []string{"dave", "data4", "read"}))
+	assert.Nil(t, a2.RemovePolicyCtx(ctx, "p", "p", []string{"alice", "data1", "read"}))
+	latest, err := a2.LatestChange(ctx)
+	assert.Nil(t, err)
+	cursor, err := a1.LoadPolicySince(ctx, m, since)
+	assert.Nil(t, err)
+	assert.Equal(t, latest, cursor)
+	policy, _ := m.GetPolicy("p", "p")
+	assert.True(t, arrayEqualsWithoutOrder([][]string{{"alice", "data1", "read"}, {"bob", "data2", "write"}, {"data2_admin", "data2", "read"}, {"data2_admin", "data2", "write"}, {"dave", "data4", "read"}}, policy))
+
+	// The audit log only holds the changes of the tenant.
+	entries, err := a1.AuditLog(ctx, AuditQuery{Limit: 1})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"dave", "data4", "read"}, entries[0].NewRule)
+	entries, err = a2.AuditLog(ctx, AuditQuery{Limit: 1})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"alice", "data1", "read"}, entries[0].OldRule)
+
+	// The snapshots of the other tenant are neither listed, restored nor deleted.
+	id, err := a1.Snapshot(ctx, "t1")
+	assert.Nil(t, err)
+	snapshots, err := a2.ListSnapshots(ctx)
+	assert.Nil(t, err)
+	for _, snapshot := range snapshots {
+		assert.NotEqual(t, id, snapshot.ID)
+	}
+	assert.NotNil(t, a2.RestoreSnapshot(ctx, id))
+	assert.NotNil(t, a2.LoadSnapshot(m, id))
+	assert.Nil(t, a2.DeleteSnapshot(ctx, id))
+	snapshots, err = a1.ListSnapshots(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, id, snapshots[0].ID)
+	assert.Equal(t, 6, snapshots[0].Rules)
+	assert.Nil(t, a1.DeleteSnapshot(ctx, id))
+}
@@ -0,0 +1,51 @@
+package gfadapter
+
+import (
+	"context"
+	"errors"
+
+	"github.com/casbin/casbin/v2/persist"
+	"github.com/gogf/gf/v2/database/gdb"
+)
+
+// errTransactionDone is returned by the adapter of a transaction that has been committed or rolled back.
+var errTransactionDone = errors.New("transaction has already been committed or rolled back")
+
+// transaction is the persist.TransactionContext of the Adapter.
+type transaction struct {
+	tx      gdb.TX
+	adapter *Adapter
+}
+
+// BeginTransaction starts a transaction on the master database.
+// The rules added, removed and updated through the adapter returned by GetAdapter,
+// and their change log entries, are only stored once Commit is called.
+// Calling neither Commit nor Rollback keeps a connection busy until the transaction times out.
+func (a *Adapter) BeginTransaction(ctx context.Context) (persist.TransactionContext, error) {
+	tx, err := a.dao.DB().Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &transaction{tx: tx, adapter: a.WithTx(tx)}, nil
+}
+
+// Commit commits the transaction.
+func (t *transaction) Commit() error {
+	if t.tx.IsClosed() {
+		return errTransactionDone
+	}
+	return t.tx.Commit()
+}
+
+// Rollback rolls back the transaction.
+func (t *transaction) Rollback() error {
+	if t.tx.IsClosed() {
+		return errTransactionDone
+	}
+	return t.tx.Rollback()
+}
+
+// GetAdapter returns the adapter writing in the transaction.
+func (t *transaction) GetAdapter() persist.Adapter {
+	return t.adapter
+}
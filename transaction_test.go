@@ -0,0 +1,72 @@
+package gfadapter
+
+import (
+	"context"
+	"testing"
+
+	"github.com/casbin/casbin/v2"
+	"github.com/casbin/casbin/v2/persist"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTransactionRollback(t *testing.T) {
+	a := initAdapter(t)
+	ctx := context.Background()
+
+	tc, err := a.BeginTransaction(ctx)
+	assert.Nil(t, err)
+	txAdapter := tc.GetAdapter().(persist.BatchAdapter)
+	assert.Nil(t, txAdapter.AddPolicies("p", "p", [][]string{{"carol", "data3", "read"}}))
+	assert.Nil(t, txAdapter.RemovePolicies("p", "p", [][]string{{"bob", "data2", "write"}}))
+	// The batch fails midway on an existing rule.
+	var existsErr *RuleExistsError
+	assert.ErrorAs(t, txAdapter.AddPolicies("p", "p", [][]string{{"alice", "data1", "read"}}), &existsErr)
+	assert.Nil(t, tc.Rollback())
+	assert.Equal(t, errTransactionDone, tc.Commit())
+
+	testSaveLoad(t, a)
+}
+
+func TestTransactionCommit(t *testing.T) {
+	a := initAdapter(t)
+	ctx := context.Background()
+
+	tc, err := a.BeginTransaction(ctx)
+	assert.Nil(t, err)
+	txAdapter := tc.GetAdapter().(*Adapter)
+	assert.Nil(t, txAdapter.AddPolicy("p", "p", []string{"carol", "data3", "read"}))
+	assert.Nil(t, txAdapter.UpdatePolicy("p", "p", []string{"bob", "data2", "write"}, []string{"bob", "data2", "read"}))
+	assert.Nil(t, txAdapter.RemovePolicy("g", "g", []string{"alice", "data2_admin"}))
+
+	// The changes are not visible outside the transaction before the commit.
+	e, _ := casbin.NewEnforcer("examples/rbac_model.conf", a)
+	testGetPolicy(t, e, [][]string{{"alice", "data1", "read"}, {"bob", "data2", "write"}, {"data2_admin", "data2", "read"}, {"data2_admin", "data2", "write"}})
+
+	assert.Nil(t, tc.Commit())
+	assert.Equal(t, errTransactionDone, tc.Rollback())
+	assert.Nil(t, e.LoadPolicy())
+	testGetPolicy(t, e, [][]string{{"alice", "data1", "read"}, {"bob", "data2", "read"}, {"carol", "data3", "read"}, {"data2_admin", "data2", "read"}, {"data2_admin", "data2", "write"}})
+	grouping, _ := e.GetGroupingPolicy()
+	assert.Empty(t, grouping)
+}
+
+func TestTransactionalEnforcer(t *testing.T) {
+	a := initAdapter(t)
+	e, err := casbin.NewTransactionalEnforcer("examples/rbac_model.conf", a)
+	assert.Nil(t, err)
+
+	err = e.WithTransaction(context.Background(), func(tx *casbin.Transaction) error {
+		if _, err := tx.AddPolicy("carol", "data3", "read"); err != nil {
+			return err
+		}
+		_, err := tx.RemoveGroupingPolicy("alice", "data2_admin")
+		return err
+	})
+	assert.Nil(t, err)
+
+	loaded, err := casbin.NewEnforcer("examples/rbac_model.conf", a)
+	assert.Nil(t, err)
+	testGetPolicy(t, loaded, [][]string{{"alice", "data1", "read"}, {"bob", "data2", "write"}, {"data2_admin", "data2", "read"}, {"data2_admin", "data2", "write"}, {"carol", "data3", "read"}})
+	grouping, _ := loaded.GetGroupingPolicy()
+	assert.Empty(t, grouping)
+}
@@ -0,0 +1,170 @@
+package gfadapter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/gogf/gf/v2/database/gdb"
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/util/gconv"
+)
+
+// errValidityDisabled is returned by the validity functions of an adapter created without WithValidityWindows.
+var errValidityDisabled = errors.New("validity windows are not enabled, create the adapter with WithValidityWindows")
+
+// validFromColumn is the start of the validity window of a rule, NULL if the rule is valid since it was added.
+var validFromColumn = columnDef{name: "valid_from", kind: kindTime}
+
+// validUntilColumn is the end of the validity window of a rule, NULL if the rule does not expire.
+var validUntilColumn = columnDef{name: "valid_until", kind: kindTime}
+
+// validUntilIndex speeds up PurgeExpired.
+var validUntilIndex = indexDef{name: "idx_valid_until", columns: []string{validUntilColumn.name}}
+
+// AddPolicyWithExpiry adds a policy rule valid from validFrom until validUntil to the storage.
+func (a *Adapter) AddPolicyWithExpiry(sec string, ptype string, rule []string, validFrom, validUntil time.Time) error {
+	return a.AddPolicyWithExpiryCtx(context.Background(), sec, ptype, rule, validFrom, validUntil)
+}
+
+// AddPolicyWithExpiryCtx adds a policy rule valid from validFrom until validUntil to the storage.
+// A zero validFrom makes the rule valid at once and a zero validUntil makes it valid forever.
+// The rule is loaded by the loads happening within its window, it is not added to the model of any enforcer.
+// It is only recorded in the change log if it is valid now, as the other instances would load it too early otherwise.
+// It returns a *RuleExistsError if the rule already exists and is valid now,
+// a stored rule outside its window gets the new window.
+func (a *Adapter) AddPolicyWithExpiryCtx(ctx context.Context, sec string, ptype string, rule []string, validFrom, validUntil time.Time) error {
+	if !a.validity {
+		return errValidityDisabled
+	}
+	a, err := a.forTenant(ctx)
+	if err != nil {
+		return err
+	}
+	if !validFrom.IsZero() && !validUntil.IsZero() && !validUntil.After(validFrom) {
+		return fmt.Errorf("the validity window ends at %s before it starts at %s", validUntil, validFrom)
+	}
+	line, err := a.savePolicyLine(ptype, rule)
+	if err != nil {
+		return err
+	}
+	line[validFromColumn.name] = timeOrNil(validFrom)
+	line[validUntilColumn.name] = timeOrNil(validUntil)
+
+	now := time.Now()
+	valid := (validFrom.IsZero() || !validFrom.After(now)) && (validUntil.IsZero() || validUntil.After(now))
+	err = a.dao.Transaction(ctx, func(ctx context.Context, tx gdb.TX) error {
+		if err := a.insertPolicyLines(ctx, tx, ptype, []g.Map{line}); err != nil {
+			return err
+		}
+		return a.recordChange(ctx, tx, change{op: ChangeAdd, sec: sec, ptype: ptype, rules: [][]string{rule}, auditOnly: !valid})
+	})
+	if errors.Is(err, errConcurrentInsert) {
+		return &RuleExistsError{Ptype: ptype, Rules: [][]string{rule}}
+	}
+	return err
+}
+
+// PurgeExpired deletes the rules whose validity window has ended and returns the number of deleted rules.
+// The deletions are recorded in the change log, so the watchers of the other instances remove the rules from their enforcers.
+// With WithSoftDelete the rows are deleted rather than marked, the soft deleted rules are left to PurgeDeleted.
+// It is meant to be called periodically, e.g. by a gcron job.
+func (a *Adapter) PurgeExpired(ctx context.Context) (int64, error) {
+	if !a.validity {
+		return 0, errValidityDisabled
+	}
+	a, err := a.forTenant(ctx)
+	if err != nil {
+		return 0, err
+	}
+	cols := a.dao.Columns()
+	var purged int64
+	err = a.dao.Transaction(ctx, func(ctx context.Context, tx gdb.TX) error {
+		result, err := a.dao.Ctx(ctx).TX(tx).
+			Fields(append([]string{cols.Id}, a.ruleFields()...)).
+			WhereLTE(validUntilColumn.name, time.Now()).
+			Order(cols.Id).
+			All()
+		if err != nil || len(result) == 0 {
+			return err
+		}
+		ids := gconv.Int64s(result.Array(cols.Id))
+		for start := 0; start < len(ids); start += flushEvery {
+			end := min(start+flushEvery, len(ids))
+			if _, err = a.dao.Ctx(ctx).TX(tx).Unscoped().WhereIn(cols.Id, ids[start:end]).Delete(); err != nil {
+				return err
+			}
+		}
+		purged = int64(len(ids))
+
+		// A change covers the rules of one ptype.
+		rules := make(map[string][][]string)
+		for _, line := range a.policyLines(result) {
+			if len(line) > 0 {
+				rules[line[0]] = append(rules[line[0]], line[1:])
+			}
+		}
+		ptypes := make([]string, 0, len(rules))
+		for ptype := range rules {
+			ptypes = append(ptypes, ptype)
+		}
+		sort.Strings(ptypes)
+		for _, ptype := range ptypes {
+			err = a.recordChange(ctx, tx, change{op: ChangeRemove, sec: ptype[:1], ptype: ptype, rules: rules[ptype]})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return purged, nil
+}
+
+// whereValid restricts m to the rules within their validity window at now, if validity windows are enabled.
+func (a *Adapter) whereValid(m *gdb.Model, now time.Time) *gdb.Model {
+	if !a.validity {
+		return m
+	}
+	from, until := a.quote(validFromColumn.name), a.quote(validUntilColumn.name)
+	return m.Where(
+		fmt.Sprintf("(%s IS NULL OR %s <= ?) AND (%s IS NULL OR %s > ?)", from, from, until, until),
+		now, now,
+	)
+}
+
+// nextValidityChange returns the earliest time after now at which a rule enters or leaves its validity window,
+// or the zero time if there is none or the validity windows are disabled.
+func (a *Adapter) nextValidityChange(ctx context.Context, now time.Time) (time.Time, error) {
+	if !a.validity {
+		return time.Time{}, nil
+	}
+	var next time.Time
+	for _, column := range []string{validFromColumn.name, validUntilColumn.name} {
+		value, err := a.loadModel(ctx).Fields(column).WhereGT(column, now).OrderAsc(column).Value()
+		if err != nil {
+			return time.Time{}, err
+		}
+		if t := value.Time(); !t.IsZero() && (next.IsZero() || t.Before(next)) {
+			next = t
+		}
+	}
+	return next, nil
+}
+
+// quote quotes a column name for the database of the adapter.
+func (a *Adapter) quote(column string) string {
+	return a.dao.DB().GetCore().QuoteWord(column)
+}
+
+// timeOrNil returns nil for the zero time, so it is stored as NULL.
+func timeOrNil(t time.Time) interface{} {
+	if t.IsZero() {
+		return nil
+	}
+	return t
+}
@@ -0,0 +1,131 @@
+package gfadapter
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/casbin/casbin/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidityWindows(t *testing.T) {
+	a, err := NewAdapterWithOptions(
+		WithTableName("test_casbin_rule_validity"),
+		WithAutoCreateTable(true),
+		WithValidityWindows(true),
+	)
+	assert.Nil(t, err)
+	ctx := context.Background()
+	// Rules outside their window are kept by SavePolicy, so remove the ones of the previous runs.
+	_, err = a.dao.Ctx(ctx).WhereGT(a.dao.Columns().Id, 0).Delete()
+	assert.Nil(t, err)
+	initPolicy(t, a)
+
+	now := time.Now()
+	assert.Nil(t, a.AddPolicyWithExpiryCtx(ctx, "p", "p", []string{"carol", "data3", "read"}, now.Add(-2*time.Hour), now.Add(-time.Hour)))
+	assert.Nil(t, a.AddPolicyWithExpiryCtx(ctx, "p", "p", []string{"dave", "data3", "read"}, now.Add(time.Hour), time.Time{}))
+	assert.Nil(t, a.AddPolicyWithExpiryCtx(ctx, "p", "p", []string{"erin", "data3", "read"}, time.Time{}, now.Add(time.Hour)))
+	assert.NotNil(t, a.AddPolicyWithExpiryCtx(ctx, "p", "p", []string{"erin", "data3", "read"}, time.Time{}, now.Add(2*time.Hour)))
+	assert.NotNil(t, a.AddPolicyWithExpiryCtx(ctx, "p", "p", []string{"frank", "data3", "read"}, now, now.Add(-time.Hour)))
+
+	e, err := casbin.NewEnforcer("examples/rbac_model.conf", a)
+	assert.Nil(t, err)
+	testGetPolicy(t, e, [][]string{{"alice", "data1", "read"}, {"bob", "data2", "write"}, {"data2_admin", "data2", "read"}, {"data2_admin", "data2", "write"}, {"erin", "data3", "read"}})
+
+	// Saving the loaded rules keeps the rules outside their window.
+	assert.Nil(t, e.SavePolicy())
+	count, err := a.dao.Ctx(ctx).Count()
+	assert.Nil(t, err)
+	assert.Equal(t, 8, count)
+
+	purged, err := a.PurgeExpired(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), purged)
+
+	// Adding a rule whose window has not started makes it valid at once.
+	_, err = e.AddPolicy("dave", "data3", "read")
+	assert.Nil(t, err)
+	assert.Nil(t, e.LoadPolicy())
+	ok, err := e.HasPolicy("dave", "data3", "read")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	count, err = a.dao.Ctx(ctx).Count()
+	assert.Nil(t, err)
+	assert.Equal(t, 7, count)
+
+	_, err = (&Adapter{}).PurgeExpired(ctx)
+	assert.Equal(t, errValidityDisabled, err)
+	assert.Equal(t, errValidityDisabled, (&Adapter{}).AddPolicyWithExpiryCtx(ctx, "p", "p", []string{"erin", "data3", "read"}, time.Time{}, now.Add(time.Hour)))
+}
+
+func TestValidityFilteredLoad(t *testing.T) {
+	a, err := NewAdapterWithOptions(
+		WithTableName("test_casbin_rule_validity"),
+		WithAutoCreateTable(true),
+		WithValidityWindows(true),
+	)
+	assert.Nil(t, err)
+	ctx := context.Background()
+	_, err = a.dao.Ctx(ctx).WhereGT(a.dao.Columns().Id, 0).Delete()
+	assert.Nil(t, err)
+	initPolicy(t, a)
+
+	now := time.Now()
+	assert.Nil(t, a.AddPolicyWithExpiryCtx(ctx, "p", "p", []string{"alice", "data3", "read"}, time.Time{}, now.Add(-time.Minute)))
+	assert.Nil(t, a.AddPolicyWithExpiryCtx(ctx, "p", "p", []string{"alice", "data4", "read"}, now.Add(time.Hour), time.Time{}))
+	assert.Nil(t, a.AddPolicyWithExpiryCtx(ctx, "g", "g", []string{"alice", "data3_admin"}, time.Time{}, now.Add(-time.Minute)))
+
+	// The filtered loads skip the rules outside their window like LoadPolicy.
+	e, err := casbin.NewEnforcer("examples/rbac_model.conf")
+	assert.Nil(t, err)
+	e.SetAdapter(a)
+	assert.Nil(t, e.LoadFilteredPolicy(Filter{V0: []string{"alice"}}))
+	testGetPolicy(t, e, [][]string{{"alice", "data1", "read"}})
+	grouping, _ := e.GetGroupingPolicy()
+	assert.Equal(t, [][]string{{"alice", "data2_admin"}}, grouping)
+	ok, err := e.Enforce("alice", "data3", "read")
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	// Only the ended windows are purged, the rule whose window has not started is kept.
+	purged, err := a.PurgeExpired(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), purged)
+	count, err := a.dao.Ctx(ctx).Count()
+	assert.Nil(t, err)
+	assert.Equal(t, 6, count)
+}
+
+func TestPurgeExpiredSoftDelete(t *testing.T) {
+	a, err := NewAdapterWithOptions(
+		WithTableName("test_casbin_rule_validity_soft_delete"),
+		WithAutoCreateTable(true),
+		WithValidityWindows(true),
+		WithSoftDelete(true),
+	)
+	assert.Nil(t, err)
+	ctx := context.Background()
+	_, err = a.dao.Ctx(ctx).Unscoped().WhereGT(a.dao.Columns().Id, 0).Delete()
+	assert.Nil(t, err)
+	initPolicy(t, a)
+
+	now := time.Now()
+	assert.Nil(t, a.AddPolicyWithExpiryCtx(ctx, "p", "p", []string{"carol", "data3", "read"}, time.Time{}, now.Add(-time.Minute)))
+	assert.Nil(t, a.AddPolicyWithExpiryCtx(ctx, "p", "p", []string{"dave", "data3", "read"}, time.Time{}, now.Add(-time.Minute)))
+	assert.Nil(t, a.RemovePolicyCtx(ctx, "p", "p", []string{"dave", "data3", "read"}))
+
+	// The expired rows are deleted rather than marked, the soft deleted one is left to PurgeDeleted.
+	purged, err := a.PurgeExpired(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), purged)
+	count, err := a.dao.Ctx(ctx).Unscoped().Count()
+	assert.Nil(t, err)
+	assert.Equal(t, 6, count)
+	purged, err = a.PurgeDeleted(ctx, time.Time{})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), purged)
+	count, err = a.dao.Ctx(ctx).Unscoped().Count()
+	assert.Nil(t, err)
+	assert.Equal(t, 5, count)
+}
@@ -0,0 +1,295 @@
+package gfadapter
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/casbin/casbin/v2/model"
+	"github.com/casbin/casbin/v2/persist"
+	"github.com/gogf/gf/v2/database/gdb"
+	"github.com/gogf/gf/v2/frame/g"
+)
+
+// DefaultPollInterval is the interval between two reads of the change log used when no interval is given.
+const DefaultPollInterval = time.Second
+
+// DefaultGapTimeout is how long a missing sequence number is waited for when no timeout is given.
+const DefaultGapTimeout = 10 * time.Second
+
+// pollLimit is the maximum number of changes read at once.
+const pollLimit = 1000
+
+var (
+	// Check if the persist.*Watcher interface is implemented
+	_ persist.Watcher          = new(Watcher)
+	_ persist.WatcherEx        = new(Watcher)
+	_ persist.UpdatableWatcher = new(Watcher)
+)
+
+// WatcherOption configures a Watcher created by NewWatcher.
+type WatcherOption func(*Watcher)
+
+// WithPollInterval sets the interval between two reads of the change log.
+func WithPollInterval(interval time.Duration) WatcherOption {
+	return func(w *Watcher) {
+		w.interval = interval
+	}
+}
+
+// WithGapTimeout sets how long a missing sequence number is waited for.
+// Sequence numbers are allocated when a change is written but become visible when its transaction commits,
+// so a missing number is either a transaction still running or a rolled back one.
+func WithGapTimeout(timeout time.Duration) WatcherOption {
+	return func(w *Watcher) {
+		w.gapTimeout = timeout
+	}
+}
+
+// WithErrorHandler sets the function called when reading the change log fails, errors are logged by default.
+func WithErrorHandler(handler func(err error)) WatcherOption {
+	return func(w *Watcher) {
+		w.onError = handler
+	}
+}
+
+// Watcher notifies the enforcer of the changes made by other instances sharing the rule table.
+// It polls the change log written by an Adapter created with WithChangeLog.
+// The changes are recorded by the adapter in the transaction changing the rules,
+// so the UpdateFor methods called by the enforcer do nothing.
+// An adapter with a fixed tenant, see WithTenantScope, is only notified of the changes of its tenant.
+type Watcher struct {
+	adapter    *Adapter
+	interval   time.Duration
+	gapTimeout time.Duration
+	onError    func(err error)
+
+	mu        sync.Mutex
+	callback  func(string)
+	changes   *changeCursor
+	closeOnce sync.Once
+	stop      chan struct{}
+	done      chan struct{}
+}
+
+// NewWatcher creates a Watcher reading the change log of the given adapter.
+// Only the changes recorded after the watcher is created are reported.
+func NewWatcher(a *Adapter, opts ...WatcherOption) (*Watcher, error) {
+	if a.changeLogTable == "" {
+		return nil, errChangeLogDisabled
+	}
+	w := &Watcher{
+		adapter:    a,
+		interval:   DefaultPollInterval,
+		gapTimeout: DefaultGapTimeout,
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(w)
+	}
+	if w.interval <= 0 {
+		return nil, errors.New("the poll interval must be positive")
+	}
+	if w.onError == nil {
+		w.onError = func(err error) {
+			g.Log().Errorf(context.Background(), "casbin watcher: %+v", err)
+		}
+	}
+
+	cursor, err := a.LatestChange(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	w.changes = newChangeCursor(cursor, w.gapTimeout)
+	go w.run()
+	return w, nil
+}
+
+// SetUpdateCallback sets the callback called when other instances changed the rules.
+// The callback receives the sequence number of the latest change as a decimal string,
+// the rules can be updated with Adapter.LoadPolicySince rather than loaded again.
+func (w *Watcher) SetUpdateCallback(callback func(string)) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.callback = callback
+	return nil
+}
+
+// Update asks the other instances to reload all rules.
+func (w *Watcher) Update() error {
+	return w.adapter.recordChange(context.Background(), nil, change{op: ChangeReload})
+}
+
+// Close stops polling the change log, the callback is not called any more.
+func (w *Watcher) Close() {
+	w.closeOnce.Do(func() {
+		close(w.stop)
+	})
+	<-w.done
+}
+
+// UpdateForAddPolicy does nothing, the change is recorded by the adapter.
+func (w *Watcher) UpdateForAddPolicy(sec, ptype string, params ...string) error {
+	return nil
+}
+
+// UpdateForRemovePolicy does nothing, the change is recorded by the adapter.
+func (w *Watcher) UpdateForRemovePolicy(sec, ptype string, params ...string) error {
+	return nil
+}
+
+// UpdateForRemoveFilteredPolicy does nothing, the change is recorded by the adapter.
+func (w *Watcher) UpdateForRemoveFilteredPolicy(sec, ptype string, fieldIndex int, fieldValues ...string) error {
+	return nil
+}
+
+// UpdateForSavePolicy does nothing, the change is recorded by the adapter.
+func (w *Watcher) UpdateForSavePolicy(model model.Model) error {
+	return nil
+}
+
+// UpdateForAddPolicies does nothing, the change is recorded by the adapter.
+func (w *Watcher) UpdateForAddPolicies(sec string, ptype string, rules ...[]string) error {
+	return nil
+}
+
+// UpdateForRemovePolicies does nothing, the change is recorded by the adapter.
+func (w *Watcher) UpdateForRemovePolicies(sec string, ptype string, rules ...[]string) error {
+	return nil
+}
+
+// UpdateForUpdatePolicy does nothing, the change is recorded by the adapter.
+func (w *Watcher) UpdateForUpdatePolicy(sec string, ptype string, oldRule, newRule []string) error {
+	return nil
+}
+
+// UpdateForUpdatePolicies does nothing, the change is recorded by the adapter.
+func (w *Watcher) UpdateForUpdatePolicies(sec string, ptype string, oldRules, newRules [][]string) error {
+	return nil
+}
+
+// run polls the change log until the watcher is closed.
+func (w *Watcher) run() {
+	defer close(w.done)
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-ticker.C:
+			if err := w.poll(context.Background()); err != nil {
+				w.onError(err)
+			}
+		}
+	}
+}
+
+// poll reads the changes above the cursor and calls the callback if other instances made any.
+func (w *Watcher) poll(ctx context.Context) error {
+	w.mu.Lock()
+	cursor := w.changes.cursor
+	w.mu.Unlock()
+
+	fields := []string{"id", "source"}
+	if w.adapter.tenantLogs {
+		fields = append(fields, tenantColumn.name)
+	}
+	result, err := w.adapter.model(ctx, w.adapter.changeLogTable).
+		Fields(fields).
+		WhereGT("id", cursor).
+		Order("id").
+		Limit(pollLimit).
+		All()
+	if err != nil {
+		return err
+	}
+
+	var latest int64
+	w.mu.Lock()
+	ids := make([]int64, 0, len(result))
+	for _, record := range result {
+		id := record["id"].Int64()
+		ids = append(ids, id)
+		if w.changes.seen[id] {
+			continue
+		}
+		if record["source"].String() != w.adapter.instanceID && w.ownTenant(record) {
+			latest = id
+		}
+	}
+	w.changes.advance(ids, time.Now())
+	callback := w.callback
+	w.mu.Unlock()
+
+	if latest > 0 && callback != nil {
+		callback(strconv.FormatInt(latest, 10))
+	}
+	return nil
+}
+
+// ownTenant reports whether the change belongs to the fixed tenant of the adapter.
+// The changes of all tenants are reported if the tenant is taken from the context.
+func (w *Watcher) ownTenant(record gdb.Record) bool {
+	return !w.adapter.tenantLogs || w.adapter.tenantID == "" || record[tenantColumn.name].String() == w.adapter.tenantID
+}
+
+// changeCursor tracks the sequence numbers of the change log read so far.
+// Sequence numbers are allocated when a change is written but become visible when its transaction commits,
+// so the cursor stops at a missing number until it shows up or has been missed for longer than the gap timeout.
+type changeCursor struct {
+	cursor     int64               // cursor is the sequence number up to which all changes have been seen.
+	seen       map[int64]bool      // seen holds the sequence numbers above the cursor already seen.
+	gaps       map[int64]time.Time // gaps holds the missing sequence numbers above the cursor with the time they were first missed.
+	gapTimeout time.Duration
+}
+
+// newChangeCursor creates a changeCursor starting after the given sequence number.
+func newChangeCursor(cursor int64, gapTimeout time.Duration) *changeCursor {
+	return &changeCursor{
+		cursor:     cursor,
+		seen:       make(map[int64]bool),
+		gaps:       make(map[int64]time.Time),
+		gapTimeout: gapTimeout,
+	}
+}
+
+// miss records that the given sequence number was missing at the given time, unless it was missed before.
+func (c *changeCursor) miss(id int64, at time.Time) {
+	if _, ok := c.gaps[id]; !ok && id > c.cursor && !c.seen[id] {
+		c.gaps[id] = at
+	}
+}
+
+// advance marks the given sequence numbers as seen and moves the cursor
+// over the seen numbers and the gaps missed for longer than the gap timeout.
+func (c *changeCursor) advance(ids []int64, now time.Time) {
+	if len(ids) == 0 {
+		return
+	}
+	for _, id := range ids {
+		c.seen[id] = true
+		delete(c.gaps, id)
+	}
+	blocked := false
+	for next := c.cursor + 1; next <= ids[len(ids)-1]; next++ {
+		if !c.seen[next] {
+			first, ok := c.gaps[next]
+			if !ok {
+				first = now
+				c.gaps[next] = now
+			}
+			if now.Sub(first) < c.gapTimeout {
+				blocked = true
+			}
+		}
+		if !blocked {
+			delete(c.seen, next)
+			delete(c.gaps, next)
+			c.cursor = next
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package gfadapter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/casbin/casbin/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWatcherAdvance(t *testing.T) {
+	w := newChangeCursor(0, time.Second)
+	now := time.Now()
+
+	w.advance([]int64{1, 2, 4}, now)
+	// 3 may belong to a transaction still running.
+	assert.Equal(t, int64(2), w.cursor)
+	assert.True(t, w.seen[4])
+
+	w.advance([]int64{3, 4}, now)
+	assert.Equal(t, int64(4), w.cursor)
+	assert.Empty(t, w.seen)
+
+	w.advance([]int64{6}, now)
+	assert.Equal(t, int64(4), w.cursor)
+	// 5 is given up once the gap timeout has passed.
+	w.advance([]int64{6}, now.Add(2*time.Second))
+	assert.Equal(t, int64(6), w.cursor)
+	assert.Empty(t, w.gaps)
+
+	// A number missed before is given up by the time it was first missed.
+	w.miss(7, now)
+	w.advance([]int64{8}, now.Add(2*time.Second))
+	assert.Equal(t, int64(8), w.cursor)
+	w.miss(9, now.Add(2*time.Second))
+	w.advance([]int64{10}, now.Add(2*time.Second))
+	assert.Equal(t, int64(8), w.cursor)
+}
+
+func TestWatcher(t *testing.T) {
+	opts := []Option{WithTableName("test_casbin_rule_watch"), WithAutoCreateTable(true), WithChangeLog("")}
+	a1, err := NewAdapterWithOptions(opts...)
+	assert.Nil(t, err)
+	a2, err := NewAdapterWithOptions(opts...)
+	assert.Nil(t, err)
+	initPolicy(t, a1)
+
+	_, err = NewWatcher(a1, WithPollInterval(0))
+	assert.NotNil(t, err)
+
+	w1, err := NewWatcher(a1, WithPollInterval(10*time.Millisecond))
+	assert.Nil(t, err)
+	defer w1.Close()
+	w2, err := NewWatcher(a2, WithPollInterval(10*time.Millisecond))
+	assert.Nil(t, err)
+	defer w2.Close()
+
+	e1, err := casbin.NewEnforcer("examples/rbac_model.conf", a1)
+	assert.Nil(t, err)
+	assert.Nil(t, e1.SetWatcher(w1))
+	e2, err := casbin.NewEnforcer("examples/rbac_model.conf", a2)
+	assert.Nil(t, err)
+	updated := make(chan string, 10)
+	assert.Nil(t, w2.SetUpdateCallback(func(s string) {
+		updated <- s
+	}))
+	self := make(chan string, 10)
+	assert.Nil(t, w1.SetUpdateCallback(func(s string) {
+		self <- s
+	}))
+
+	_, err = e1.AddPolicy("carol", "data3", "read")
+	assert.Nil(t, err)
+	select {
+	case <-updated:
+		assert.Nil(t, e2.LoadPolicy())
+		ok, err := e2.Enforce("carol", "data3", "read")
+		assert.Nil(t, err)
+		assert.True(t, ok)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the change was not reported to the other instance")
+	}
+
+	// Changes made by an instance are not reported to itself.
+	select {
+	case <-self:
+		t.Fatal("the change was reported to its own instance")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestWatcherWithoutChangeLog(t *testing.T) {
+	_, err := NewWatcher(&Adapter{})
+	assert.NotNil(t, err)
+}